@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -17,7 +16,7 @@ type Server struct {
 
 func (s *Server) Route() {
 	s.AddHandler("GET", "/", s.index())
-	s.AddHandler("GET", "/admin", s.auth(s.hello()))
+	s.AddHandler("GET", "/admin", s.hello(), s.auth)
 	s.AddHandler("GET", "/error", httpsvr.ExampleHandlerError())
 	s.AddHandler("GET", "/exception", s.exception())
 }
@@ -41,10 +40,8 @@ func (s Server) auth(handle http.HandlerFunc) http.HandlerFunc {
 		bearerAuth := r.Header.Get("Authorization")
 		words := strings.Split(bearerAuth, " ")
 		if len(words) != 2 || words[0] != "Bearer" {
-			err := errors.New("need header Authorization: Bearer {token}")
-			log.Infof("error when http respond %v: %v",
-				httpsvr.GetRequestId(r), err)
-			http.Error(w, err.Error(), 500)
+			s.WriteError(w, r, httpsvr.NewError(http.StatusUnauthorized,
+				"need header Authorization: Bearer {token}"))
 			return
 		}
 		userName := words[1]
@@ -67,10 +64,15 @@ func main() {
 	port := ":8000"
 	log.Infof("url0: http://127.0.0.1%v/", port)
 	log.Infof("url0: http://127.0.0.1%v/__metric", port)
+	log.Infof("url0: http://127.0.0.1%v/__metrics/prometheus", port)
 	log.Infof("url1: http://127.0.0.1%v/admin", port)
 	log.Infof("url2: http://127.0.0.1%v/error", port)
-	err := s.ListenAndServe(port)
+	s.OnShutdown(func(context.Context) error {
+		log.Infof("closing resources that were started alongside the server")
+		return nil
+	})
+	err := s.Run(port) // Ctrl+C to gracefully shut down
 	if err != nil {
-		log.Fatalf("error when s_ListenAndServe: %v", err)
+		log.Fatalf("error when s_Run: %v", err)
 	}
 }
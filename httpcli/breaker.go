@@ -0,0 +1,122 @@
+package httpcli
+
+import (
+	"sync"
+	"time"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+// DefaultOpenDuration is used if CircuitBreakerConfig_OpenDuration is not set
+const DefaultOpenDuration = 30 * time.Second
+
+// CircuitBreakerConfig configs per host circuit breakers. A breaker opens
+// after FailureThreshold consecutive failures (network errors or 5xx),
+// while open, requests to the host fail fast with ErrCircuitOpen.
+// After OpenDuration, one trial request is allowed (half-open): if it
+// succeeds the breaker closes, else it opens again.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is 0 means the circuit breaker is disabled
+	FailureThreshold int
+	// OpenDuration default is DefaultOpenDuration
+	OpenDuration time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker is a nil pointer if the circuit breaker is disabled
+type circuitBreaker struct {
+	host     string
+	conf     CircuitBreakerConfig
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// isTrialRunning is true if the half-open trial request has not returned
+	isTrialRunning bool
+	mutex          *sync.Mutex
+}
+
+// allow returns false if the request should not be sent
+func (b *circuitBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.conf.OpenDuration {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.isTrialRunning = true
+		return true
+	case breakerHalfOpen:
+		if b.isTrialRunning {
+			return false
+		}
+		b.isTrialRunning = true
+		return true
+	}
+	return true
+}
+
+// record updates the breaker by the result of an allowed request
+func (b *circuitBreaker) record(isSuccess bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if isSuccess {
+		if b.state != breakerClosed {
+			log.Infof("circuit breaker of %v closed", b.host)
+		}
+		b.state, b.failures, b.isTrialRunning = breakerClosed, 0, false
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.conf.FailureThreshold {
+		if b.state != breakerOpen {
+			log.Infof("circuit breaker of %v opened after %v failures",
+				b.host, b.failures)
+		}
+		b.state, b.openedAt, b.isTrialRunning = breakerOpen, now, false
+	}
+}
+
+// breakerRegistry holds a circuit breaker for each host
+type breakerRegistry struct {
+	conf     CircuitBreakerConfig
+	breakers map[string]*circuitBreaker
+	mutex    *sync.Mutex
+}
+
+func newBreakerRegistry(conf CircuitBreakerConfig) *breakerRegistry {
+	if conf.OpenDuration <= 0 {
+		conf.OpenDuration = DefaultOpenDuration
+	}
+	return &breakerRegistry{conf: conf,
+		breakers: make(map[string]*circuitBreaker), mutex: &sync.Mutex{}}
+}
+
+// get returns nil if the circuit breaker is disabled
+func (r *breakerRegistry) get(host string) *circuitBreaker {
+	if r.conf.FailureThreshold <= 0 {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b, found := r.breakers[host]
+	if !found {
+		b = &circuitBreaker{host: host, conf: r.conf, mutex: &sync.Mutex{}}
+		r.breakers[host] = b
+	}
+	return b
+}
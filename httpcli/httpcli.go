@@ -0,0 +1,261 @@
+// Package httpcli is a HTTP client that has retries, per host circuit breakers
+// and metrics, it mirrors conventions of package httpsvr
+package httpcli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/daominah/gomicrokit/httpsvr"
+	"github.com/daominah/gomicrokit/log"
+	"github.com/daominah/gomicrokit/metric"
+)
+
+// DefaultTimeout is used if Config_Timeout is not set
+const DefaultTimeout = 30 * time.Second
+
+// DefaultRequestIdHeader is used if Config_RequestIdHeader is not set
+const DefaultRequestIdHeader = httpsvr.DefaultRequestIdHeader
+
+// Config configs a Client, zero value is a valid config (no retry)
+type Config struct {
+	// Timeout is the max duration of each attempt, default DefaultTimeout
+	Timeout time.Duration
+	// MaxRetries is the max number of retries after the first attempt,
+	// only idempotent requests (GET, HEAD, PUT, DELETE, OPTIONS) are retried
+	// unless IsRetryNonIdempotent is true
+	MaxRetries           int
+	IsRetryNonIdempotent bool
+	// BackoffFunc returns the duration to wait before a retry,
+	// default DefaultBackoff
+	BackoffFunc func(retries int, maxRetries int) time.Duration
+	// RequestIdHeader is the outgoing header that holds the request id in
+	// the request context (httpsvr_CtxRequestId), default DefaultRequestIdHeader
+	RequestIdHeader string
+	// CircuitBreaker is disabled if its FailureThreshold is 0
+	CircuitBreaker CircuitBreakerConfig
+	// Transport default is http_DefaultTransport
+	Transport http.RoundTripper
+	// IsEnableLog logs every attempt
+	IsEnableLog bool
+}
+
+// DefaultBackoff returns 100ms, 200ms, 400ms, .. (same as kafka producer)
+func DefaultBackoff(retries int, maxRetries int) time.Duration {
+	ret := 100 * time.Millisecond
+	for retries > 0 {
+		ret = 2 * ret
+		retries--
+	}
+	return ret
+}
+
+// Client is safe for concurrent use, it should be reused
+type Client struct {
+	conf       Config
+	httpClient *http.Client
+	breakers   *breakerRegistry
+	// Metric observes count and duration of attempts, metric key is
+	// "host_statusClass" (ex: "api.example.com_2xx", "api.example.com_error")
+	// or "host_circuitopen" for requests rejected by the circuit breaker
+	Metric metric.Metric
+}
+
+// NewClient returns a Client that records metric in memory
+func NewClient(conf Config) *Client {
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultTimeout
+	}
+	if conf.BackoffFunc == nil {
+		conf.BackoffFunc = DefaultBackoff
+	}
+	if conf.RequestIdHeader == "" {
+		conf.RequestIdHeader = DefaultRequestIdHeader
+	}
+	return &Client{
+		conf:       conf,
+		httpClient: &http.Client{Timeout: conf.Timeout, Transport: conf.Transport},
+		breakers:   newBreakerRegistry(conf.CircuitBreaker),
+		Metric:     metric.NewMemoryMetric(),
+	}
+}
+
+// ErrCircuitOpen is returned without sending the request if the circuit
+// breaker of the host is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Do sends the request with retries, the request id in the request context
+// is sent in header Config_RequestIdHeader.
+// A request that has a body can only be retried if req_GetBody is not nil
+// (http_NewRequest sets it for bytes, strings readers).
+// Like http_Client_Do, the caller must close the response body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if requestId, ok := req.Context().Value(httpsvr.CtxRequestId).(string); ok &&
+		requestId != "" && req.Header.Get(c.conf.RequestIdHeader) == "" {
+		req.Header.Set(c.conf.RequestIdHeader, requestId)
+	}
+	maxRetries := c.conf.MaxRetries
+	if !c.conf.IsRetryNonIdempotent && !isIdempotent(req.Method) ||
+		req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		maxRetries = 0
+	}
+	breaker := c.breakers.get(req.URL.Host)
+	for retries := 0; ; retries++ {
+		if retries > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		if !breaker.allow(time.Now()) {
+			c.Metric.Count(req.URL.Host + "_circuitopen")
+			return nil, ErrCircuitOpen
+		}
+		beginTime := time.Now()
+		resp, err := c.httpClient.Do(req)
+		duration := time.Since(beginTime)
+		metricKey := req.URL.Host + "_error"
+		if err == nil {
+			metricKey = fmt.Sprintf("%v_%v", req.URL.Host, httpsvr.StatusClass(resp.StatusCode))
+		}
+		c.Metric.Count(metricKey)
+		c.Metric.Duration(metricKey, duration)
+		isFailure := err != nil || resp.StatusCode >= 500
+		breaker.record(!isFailure, time.Now())
+		if err == nil {
+			log.Condf(c.conf.IsEnableLog, "http client %v %v: status %v, duration %v",
+				req.Method, req.URL, resp.StatusCode, duration)
+		} else {
+			log.Condf(c.conf.IsEnableLog, "http client %v %v: error %v, duration %v",
+				req.Method, req.URL, err, duration)
+		}
+
+		isRetryable := err != nil || isRetryableStatus(resp.StatusCode)
+		if !isRetryable || retries >= maxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		wait := c.conf.BackoffFunc(retries, maxRetries)
+		if err == nil {
+			if retryAfter := parseRetryAfter(resp.Header); retryAfter > wait {
+				wait = retryAfter
+			}
+			io.Copy(ioutil.Discard, resp.Body) // so the connection can be reused
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "":
+		return true
+	}
+	return false
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter only supports seconds format, returns 0 if the header is
+// invalid or absent
+func parseRetryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// NewJsonRequest returns a request that has body is JSON of obj,
+// obj can be nil for a request without body
+func NewJsonRequest(ctx context.Context, method string, url string, obj interface{}) (
+	*http.Request, error) {
+	var body io.Reader
+	if obj != nil {
+		bodyB, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(bodyB)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if obj != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return req.WithContext(ctx), nil
+}
+
+// ReadJson reads the response body to outPtr and closes the body.
+// If the status code is not 2xx, returns an *httpsvr_Error (decoded from
+// the body if it is an httpsvr_ErrorResponse).
+func ReadJson(resp *http.Response, outPtr interface{}) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp httpsvr.ErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error.Code != 0 {
+			return &errResp.Error
+		}
+		return &httpsvr.Error{Code: resp.StatusCode, Message: string(body)}
+	}
+	if outPtr == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, outPtr)
+}
+
+// DoJson sends reqObj as a JSON body (nil for no body), reads JSON response
+// body to outPtr (can be nil)
+func (c *Client) DoJson(ctx context.Context, method string, url string,
+	reqObj interface{}, outPtr interface{}) error {
+	req, err := NewJsonRequest(ctx, method, url, reqObj)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	return ReadJson(resp, outPtr)
+}
+
+// GetJson sends a GET request, reads JSON response body to outPtr
+func (c *Client) GetJson(ctx context.Context, url string, outPtr interface{}) error {
+	return c.DoJson(ctx, "GET", url, nil, outPtr)
+}
+
+// PostJson sends a POST request that has body is JSON of reqObj,
+// reads JSON response body to outPtr
+func (c *Client) PostJson(ctx context.Context, url string,
+	reqObj interface{}, outPtr interface{}) error {
+	return c.DoJson(ctx, "POST", url, reqObj, outPtr)
+}
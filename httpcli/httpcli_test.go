@@ -0,0 +1,148 @@
+package httpcli
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daominah/gomicrokit/httpsvr"
+)
+
+func noBackoff(retries int, maxRetries int) time.Duration { return time.Millisecond }
+
+func TestJson(t *testing.T) {
+	type User struct {
+		Id   int64
+		Name string
+	}
+	var gotRequestId string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestId = r.Header.Get("X-Request-Id")
+		var user User
+		if err := httpsvr.ReadJson(r, &user); err != nil || user.Name == "" {
+			httpsvr.WriteError(w, r, httpsvr.NewError(400, "name is required"))
+			return
+		}
+		user.Id = 1
+		httpsvr.WriteJson(w, r, user)
+	}))
+	defer ts.Close()
+
+	c := NewClient(Config{})
+	ctx := context.WithValue(context.Background(), httpsvr.CtxRequestId, "req1")
+	var created User
+	err := c.PostJson(ctx, ts.URL, User{Name: "Tung"}, &created)
+	if err != nil || created.Id != 1 || created.Name != "Tung" {
+		t.Errorf("unexpected response %+v, error %v", created, err)
+	}
+	if gotRequestId != "req1" {
+		t.Errorf("request id: expected req1, got %v", gotRequestId)
+	}
+
+	err = c.PostJson(context.Background(), ts.URL, User{}, &created)
+	httpErr, ok := err.(*httpsvr.Error)
+	if !ok || httpErr.Code != 400 || httpErr.Message != "name is required" {
+		t.Errorf("unexpected error %#v", err)
+	}
+	u, _ := url.Parse(ts.URL)
+	for _, row := range c.Metric.GetCurrentMetric() {
+		if row.Key != u.Host+"_2xx" && row.Key != u.Host+"_4xx" || row.Count != 1 {
+			t.Errorf("unexpected metric row %+v", row)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var nCalls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&nCalls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		httpsvr.WriteJson(w, r, map[string]string{"Method": r.Method})
+	}))
+	defer ts.Close()
+	c := NewClient(Config{MaxRetries: 3, BackoffFunc: noBackoff})
+
+	var out map[string]string
+	err := c.DoJson(context.Background(), "PUT", ts.URL, map[string]int{"a": 1}, &out)
+	if err != nil || out["Method"] != "PUT" || atomic.LoadInt32(&nCalls) != 3 {
+		t.Errorf("unexpected response %v, error %v, nCalls %v", out, err, nCalls)
+	}
+
+	atomic.StoreInt32(&nCalls, 0)
+	err = c.PostJson(context.Background(), ts.URL, nil, &out)
+	if err == nil || atomic.LoadInt32(&nCalls) != 1 {
+		t.Errorf("POST should not be retried: error %v, nCalls %v", err, nCalls)
+	}
+
+	atomic.StoreInt32(&nCalls, -10)
+	err = c.GetJson(context.Background(), ts.URL, &out)
+	if err == nil || atomic.LoadInt32(&nCalls) != -6 {
+		t.Errorf("expected 4 attempts: error %v, nCalls %v", err, nCalls)
+	}
+}
+
+func TestDefaultBackoff(t *testing.T) {
+	for retries, expected := range []time.Duration{100 * time.Millisecond,
+		200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond} {
+		if got := DefaultBackoff(retries, 5); got != expected {
+			t.Errorf("retries %v: expected %v, got %v", retries, expected, got)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var isDown int32 = 1
+	var nCalls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&nCalls, 1)
+		if atomic.LoadInt32(&isDown) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	c := NewClient(Config{CircuitBreaker: CircuitBreakerConfig{
+		FailureThreshold: 3, OpenDuration: 50 * time.Millisecond}})
+
+	get := func() error {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	for i := 0; i < 5; i++ {
+		err := get()
+		if i < 3 && err != nil || i >= 3 && err != ErrCircuitOpen {
+			t.Errorf("request %v: unexpected error %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&nCalls); n != 3 {
+		t.Errorf("expected 3 calls to the server, got %v", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := get(); err != nil { // half-open trial fails
+		t.Error(err)
+	}
+	if err := get(); err != ErrCircuitOpen {
+		t.Errorf("expected circuit open after a failed trial, got %v", err)
+	}
+
+	atomic.StoreInt32(&isDown, 0)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := get(); err != nil {
+			t.Errorf("expected circuit closed, got %v", err)
+		}
+	}
+}
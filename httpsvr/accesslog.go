@@ -0,0 +1,235 @@
+package httpsvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+// Predefined access log formats, AccessLogConfig_Format can also be a
+// text/template executed with an AccessLogEntry
+const (
+	// AccessLogCombined is the Apache Combined Log Format
+	AccessLogCombined = "combined"
+	// AccessLogJSON writes an AccessLogEntry as a JSON object per line
+	AccessLogJSON = "json"
+)
+
+// AccessLogConfig configs AccessLogMiddleware
+type AccessLogConfig struct {
+	// Format can be AccessLogCombined (default), AccessLogJSON or a template,
+	// ex: `{{.Method}} {{.Path}} {{.Status}} {{.Duration}}`
+	Format string
+	// File configs the dedicated access log file (path, rotation),
+	// if File_LogFilePath is empty, access logs are written by the global logger
+	File log.Config
+	// SampleRate is the fraction of requests will be logged, in (0, 1],
+	// default 1 (log all requests). 5xx responses are always logged.
+	SampleRate float64
+	// ExcludePaths are URL paths or route patterns will not be logged,
+	// a path ends with "*" is a prefix, ex: "/__metric", "/__health", "/static/*"
+	ExcludePaths []string
+}
+
+// AccessLogEntry is data of a request/response pair
+type AccessLogEntry struct {
+	Time       time.Time
+	RequestId  string
+	RemoteAddr string
+	User       string
+	Method     string
+	Path       string
+	Query      string
+	// Route is the registered path pattern, ex: /match/:id
+	Route           string
+	Proto           string
+	Status          int
+	Size            int
+	Duration        time.Duration
+	TimeToFirstByte time.Duration
+	Referer         string
+	UserAgent       string
+}
+
+// AccessLogMiddleware logs every request/response pair as a line,
+// it should be added after LogMiddleware (to have the request id),
+// or replaces LogMiddleware by calling Server_ResetMiddlewares.
+func AccessLogMiddleware(conf AccessLogConfig) (Middleware, error) {
+	format, err := newAccessLogFormatter(conf.Format)
+	if err != nil {
+		return nil, err
+	}
+	var writer io.Writer
+	if conf.File.LogFilePath != "" {
+		writer = log.NewFileWriter(conf.File)
+	}
+	sampleRate := conf.SampleRate
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	mutex := &sync.Mutex{}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rw := NewResponseWriter(w)
+			beginTime := time.Now()
+			next(rw, r)
+			route := GetRoute(r).Path
+			if isMatchedPath(conf.ExcludePaths, r.URL.Path, route) {
+				return
+			}
+			if rw.Status() < 500 && sampleRate < 1 && rand.Float64() >= sampleRate {
+				return
+			}
+			e := AccessLogEntry{
+				Time:            beginTime,
+				RequestId:       GetRequestId(r),
+				RemoteAddr:      r.RemoteAddr,
+				Method:          r.Method,
+				Path:            r.URL.Path,
+				Query:           r.URL.RawQuery,
+				Route:           route,
+				Proto:           r.Proto,
+				Status:          rw.Status(),
+				Size:            rw.Size(),
+				Duration:        time.Since(beginTime),
+				TimeToFirstByte: rw.TimeToFirstByte(),
+				Referer:         r.Referer(),
+				UserAgent:       r.UserAgent(),
+			}
+			if user, _, ok := r.BasicAuth(); ok {
+				e.User = user
+			}
+			line, err := format(e)
+			if err != nil {
+				log.Infof("error when format access log %v: %v", e.RequestId, err)
+				return
+			}
+			if writer == nil {
+				log.Info(line)
+				return
+			}
+			mutex.Lock()
+			_, err = io.WriteString(writer, line+"\n")
+			mutex.Unlock()
+			if err != nil {
+				log.Infof("error when write access log: %v", err)
+			}
+		}
+	}, nil
+}
+
+// isMatchedPath returns true if the URL path or the route matches one of
+// patterns, a pattern ends with "*" is a prefix
+func isMatchedPath(patterns []string, urlPath string, route string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			prefix := strings.TrimSuffix(pattern, "*")
+			if strings.HasPrefix(urlPath, prefix) || strings.HasPrefix(route, prefix) {
+				return true
+			}
+			continue
+		}
+		if pattern == urlPath || pattern == route {
+			return true
+		}
+	}
+	return false
+}
+
+type accessLogFormatter func(e AccessLogEntry) (string, error)
+
+func newAccessLogFormatter(format string) (accessLogFormatter, error) {
+	switch format {
+	case "", AccessLogCombined:
+		return formatCombined, nil
+	case AccessLogJSON:
+		return formatJSON, nil
+	}
+	tmpl, err := template.New("accessLog").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("error parse access log format: %v", err)
+	}
+	return func(e AccessLogEntry) (string, error) {
+		buf := bytes.NewBuffer(nil)
+		err := tmpl.Execute(buf, e)
+		return buf.String(), err
+	}, nil
+}
+
+// formatCombined returns a line in Apache Combined Log Format:
+// host - user [time] "method uri proto" status size "referer" "userAgent"
+func formatCombined(e AccessLogEntry) (string, error) {
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+	uri := e.Path
+	if e.Query != "" {
+		uri += "?" + e.Query
+	}
+	size := "-"
+	if e.Size > 0 {
+		size = fmt.Sprintf("%v", e.Size)
+	}
+	return fmt.Sprintf(`%v - %v [%v] "%v %v %v" %v %v "%v" "%v"`,
+		host, dashIfEmpty(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, uri, e.Proto, e.Status, size,
+		dashIfEmpty(e.Referer), dashIfEmpty(e.UserAgent)), nil
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Replace(s, `"`, `\"`, -1)
+}
+
+// accessLogJsonable because time.Duration json is not readable
+type accessLogJsonable struct {
+	Time                   string
+	RequestId              string
+	RemoteAddr             string
+	User                   string
+	Method                 string
+	Path                   string
+	Query                  string
+	Route                  string
+	Proto                  string
+	Status                 int
+	Size                   int
+	DurationSeconds        float64
+	TimeToFirstByteSeconds float64
+	Referer                string
+	UserAgent              string
+}
+
+func formatJSON(e AccessLogEntry) (string, error) {
+	bodyB, err := json.Marshal(accessLogJsonable{
+		Time:                   e.Time.Format(time.RFC3339Nano),
+		RequestId:              e.RequestId,
+		RemoteAddr:             e.RemoteAddr,
+		User:                   e.User,
+		Method:                 e.Method,
+		Path:                   e.Path,
+		Query:                  e.Query,
+		Route:                  e.Route,
+		Proto:                  e.Proto,
+		Status:                 e.Status,
+		Size:                   e.Size,
+		DurationSeconds:        e.Duration.Seconds(),
+		TimeToFirstByteSeconds: e.TimeToFirstByte.Seconds(),
+		Referer:                e.Referer,
+		UserAgent:              e.UserAgent,
+	})
+	return string(bodyB), err
+}
@@ -0,0 +1,101 @@
+package httpsvr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+func TestAccessLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpsvr_accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, c := range []struct {
+		format string
+		check  func(line string) bool
+	}{
+		{AccessLogCombined, regexp.MustCompile(
+			`^192\.0\.2\.1 - - \[.+\] "GET /users/1\?q=a HTTP/1\.1" 200 26 "-" "tester"$`,
+		).MatchString},
+		{AccessLogJSON, func(line string) bool {
+			var e struct{ Route, UserAgent string }
+			err := json.Unmarshal([]byte(line), &e)
+			return err == nil && e.Route == "/users/:id" && e.UserAgent == "tester"
+		}},
+		{`{{.Method}} {{.Route}} {{.Status}}`, func(line string) bool {
+			return line == "GET /users/:id 200"
+		}},
+	} {
+		filePath := filepath.Join(dir, "access.log")
+		os.Remove(filePath)
+		accessLog, err := AccessLogMiddleware(AccessLogConfig{
+			Format:       c.format,
+			File:         log.Config{LogFilePath: filePath, IsNotLogRotate: true},
+			ExcludePaths: []string{"/__metric", "/static/*"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewServer()
+		s.Use(accessLog)
+		s.AddHandler("GET", "/users/:id", ExampleHandler())
+		s.AddHandler("GET", "/static/*file", ExampleHandler())
+		for _, path := range []string{"/users/1?q=a", "/__metric", "/static/a.js"} {
+			r := httptest.NewRequest("GET", path, nil)
+			r.Header.Set("User-Agent", "tester")
+			s.router.ServeHTTP(httptest.NewRecorder(), r)
+		}
+		content, _ := ioutil.ReadFile(filePath)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != 1 || !c.check(lines[0]) {
+			t.Errorf("case %v: unexpected access log: %q", i, content)
+		}
+	}
+
+	_, err = AccessLogMiddleware(AccessLogConfig{Format: "{{.Method"})
+	if err == nil {
+		t.Error("expected error when parse invalid template")
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpsvr_accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "access.log")
+	accessLog, err := AccessLogMiddleware(AccessLogConfig{
+		Format:     `{{.Status}}`,
+		File:       log.Config{LogFilePath: filePath, IsNotLogRotate: true},
+		SampleRate: 1e-9,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.Use(accessLog)
+	s.AddHandler("GET", "/", ExampleHandler())
+	s.AddHandler("GET", "/error", func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, NewError(http.StatusServiceUnavailable, "busy"))
+	})
+	for i := 0; i < 100; i++ {
+		s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/error", nil))
+	content, _ := ioutil.ReadFile(filePath)
+	if strings.TrimSpace(string(content)) != "503" {
+		t.Errorf("unexpected access log: %q", content)
+	}
+}
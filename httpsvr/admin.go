@@ -0,0 +1,224 @@
+package httpsvr
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+// DefaultCheckTimeout is used if AdminConfig_CheckTimeout is not set
+const DefaultCheckTimeout = 5 * time.Second
+
+// AdminConfig configs EnableAdminEndpoints
+type AdminConfig struct {
+	// IsEnableHealth registers GET /__health (liveness, always ok while the
+	// server is running) and GET /__ready (calls all added ReadyCheckers)
+	IsEnableHealth bool
+	// CheckTimeout is the max duration of each ReadyChecker,
+	// default DefaultCheckTimeout
+	CheckTimeout time.Duration
+	// IsEnablePprof registers pprof handlers at /debug/pprof/, they serve
+	// the same profiles as net/http/pprof
+	IsEnablePprof bool
+	// IsEnableLogLevel registers GET and PUT /__log/level to read and change
+	// log levels at runtime, see LogLevelRequest. Without AdminUsername,
+	// anyone can reach the port can change log levels.
+	IsEnableLogLevel bool
+	// if AdminUsername is not empty, pprof and log level handlers require
+	// HTTP basic auth (health handlers do not)
+	AdminUsername string
+	AdminPassword string
+}
+
+// LogLevelRequest is the body of PUT /__log/level, empty Name changes the
+// root level, empty Level makes the named logger use the root level again.
+// Ex: {"Name": "kafka", "Level": "warn"}
+type LogLevelRequest struct {
+	Name  string
+	Level string
+}
+
+// LogLevelResponse is the body of /__log/level response
+type LogLevelResponse struct {
+	Level       string
+	NamedLevels map[string]string
+}
+
+// ReadyChecker returns nil if a dependency is ready,
+// ex: kafka producer connected, websocket server listening
+type ReadyChecker func(ctx context.Context) error
+
+// CheckResult is the status of a ReadyChecker in /__ready response
+type CheckResult struct {
+	Name            string
+	IsReady         bool
+	Error           string
+	DurationSeconds float64
+}
+
+// ReadyResponse is the body of /__ready response
+type ReadyResponse struct {
+	IsReady bool
+	Checks  []CheckResult
+}
+
+// EnableAdminEndpoints registers optional health, readiness and pprof
+// endpoints, it should be called once
+func (s *Server) EnableAdminEndpoints(conf AdminConfig) {
+	if conf.IsEnableHealth {
+		timeout := conf.CheckTimeout
+		if timeout <= 0 {
+			timeout = DefaultCheckTimeout
+		}
+		s.AddHandler("GET", "/__health", s.handleHealth())
+		s.AddHandler("GET", "/__ready", s.handleReady(timeout))
+	}
+	var mws []Middleware
+	if conf.AdminUsername != "" {
+		mws = append(mws,
+			BasicAuthMiddleware(conf.AdminUsername, conf.AdminPassword))
+	}
+	if conf.IsEnablePprof {
+		s.AddHandler("GET", "/debug/pprof/*name", handlePprof(), mws...)
+		s.AddHandler("POST", "/debug/pprof/*name", handlePprof(), mws...)
+	}
+	if conf.IsEnableLogLevel {
+		s.AddHandler("GET", "/__log/level", s.handleLogLevel(), mws...)
+		s.AddHandler("PUT", "/__log/level", s.handleLogLevel(), mws...)
+	}
+}
+
+// AddReadyChecker adds a checker that will be called by /__ready,
+// adding a checker with an existed name replaces the old checker
+func (s *Server) AddReadyChecker(name string, checker ReadyChecker) {
+	s.lifecycle.mutex.Lock()
+	if _, found := s.lifecycle.readyCheckers[name]; !found {
+		s.lifecycle.readyCheckerNames = append(s.lifecycle.readyCheckerNames, name)
+	}
+	if s.lifecycle.readyCheckers == nil {
+		s.lifecycle.readyCheckers = make(map[string]ReadyChecker)
+	}
+	s.lifecycle.readyCheckers[name] = checker
+	s.lifecycle.mutex.Unlock()
+}
+
+func (s Server) handleHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.WriteJson(w, r, map[string]string{"Status": "ok"})
+	}
+}
+
+// handleReady calls all ReadyCheckers concurrently, responds 503 if a checker
+// failed or the server is shutting down
+func (s Server) handleReady(timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.lifecycle.mutex.Lock()
+		isShutdown := s.lifecycle.isShutdown
+		names := append([]string{}, s.lifecycle.readyCheckerNames...)
+		checkers := make([]ReadyChecker, len(names))
+		for i, name := range names {
+			checkers[i] = s.lifecycle.readyCheckers[name]
+		}
+		s.lifecycle.mutex.Unlock()
+
+		results := make([]CheckResult, len(names))
+		wg := &sync.WaitGroup{}
+		for i := range names {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = runChecker(r.Context(), names[i], checkers[i], timeout)
+			}(i)
+		}
+		wg.Wait()
+
+		res := ReadyResponse{IsReady: !isShutdown, Checks: results}
+		for _, result := range results {
+			if !result.IsReady {
+				res.IsReady = false
+			}
+		}
+		if !res.IsReady {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		s.WriteJson(w, r, res)
+	}
+}
+
+func runChecker(parent context.Context, name string, checker ReadyChecker,
+	timeout time.Duration) CheckResult {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	beginTime := time.Now()
+	errChan := make(chan error, 1)
+	go func() { errChan <- checker(ctx) }()
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done(): // in case the checker does not respect ctx
+		err = ctx.Err()
+	}
+	result := CheckResult{Name: name, IsReady: err == nil,
+		DurationSeconds: time.Since(beginTime).Seconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// handleLogLevel responds the current log levels, a PUT request changes
+// a level before responding
+func (s Server) handleLogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			var req LogLevelRequest
+			if err := s.ReadJson(r, &req); err != nil {
+				WriteError(w, r, NewError(http.StatusBadRequest, err.Error()))
+				return
+			}
+			if req.Name != "" && req.Level == "" {
+				log.UnsetNamedLevel(req.Name)
+			} else {
+				level, err := log.ParseLevel(req.Level)
+				if err != nil {
+					WriteError(w, r, NewError(http.StatusBadRequest, err.Error()))
+					return
+				}
+				if req.Name == "" {
+					log.SetLevel(level)
+				} else {
+					log.SetNamedLevel(req.Name, level)
+				}
+			}
+		}
+		res := LogLevelResponse{Level: log.GetLevel().String(),
+			NamedLevels: make(map[string]string)}
+		for name, level := range log.NamedLevels() {
+			res.NamedLevels[name] = level.String()
+		}
+		s.WriteJson(w, r, res)
+	}
+}
+
+// BasicAuthMiddleware requires HTTP basic auth with the username and password
+func BasicAuthMiddleware(username string, password string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			if !ok ||
+				subtle.ConstantTimeCompare([]byte(u), []byte(username)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="restricted"`)
+				WriteError(w, r, NewError(http.StatusUnauthorized,
+					http.StatusText(http.StatusUnauthorized)))
+				return
+			}
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package httpsvr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daominah/gomicrokit/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHealthAndReady(t *testing.T) {
+	s := NewServer()
+	s.EnableAdminEndpoints(AdminConfig{
+		IsEnableHealth: true, CheckTimeout: 50 * time.Millisecond})
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/__health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected health status %v", w.Code)
+	}
+
+	isKafkaConnected := true
+	s.AddReadyChecker("kafka", func(ctx context.Context) error {
+		if !isKafkaConnected {
+			return errors.New("kafka disconnected")
+		}
+		return nil
+	})
+	s.AddReadyChecker("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	getReady := func() (int, ReadyResponse) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/__ready", nil))
+		var res ReadyResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+	code, res := getReady()
+	if code != http.StatusServiceUnavailable || res.IsReady || len(res.Checks) != 2 ||
+		!res.Checks[0].IsReady || res.Checks[1].IsReady {
+		t.Errorf("unexpected ready response %v: %#v", code, res)
+	}
+
+	s.AddReadyChecker("slow", func(ctx context.Context) error { return nil })
+	isKafkaConnected = false
+	code, res = getReady()
+	if code != http.StatusServiceUnavailable || len(res.Checks) != 2 ||
+		res.Checks[0].Error != "kafka disconnected" || !res.Checks[1].IsReady {
+		t.Errorf("unexpected ready response %v: %#v", code, res)
+	}
+
+	isKafkaConnected = true
+	code, res = getReady()
+	if code != http.StatusOK || !res.IsReady {
+		t.Errorf("unexpected ready response %v: %#v", code, res)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	s := NewServer()
+	s.EnableAdminEndpoints(AdminConfig{IsEnablePprof: true,
+		AdminUsername: "admin", AdminPassword: "secret"})
+	for i, c := range []struct {
+		path       string
+		password   string
+		statusCode int
+		body       string
+	}{
+		{"/debug/pprof/", "wrong", http.StatusUnauthorized, ""},
+		{"/debug/pprof/", "secret", http.StatusOK, "goroutine"},
+		{"/debug/pprof/cmdline", "secret", http.StatusOK, ""},
+		{"/debug/pprof/goroutine?debug=1", "secret", http.StatusOK, "goroutine profile"},
+		{"/debug/pprof/trace?seconds=1", "secret", http.StatusOK, ""},
+		{"/debug/pprof/not_exist", "secret", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", c.path, nil)
+		r.SetBasicAuth("admin", c.password)
+		s.router.ServeHTTP(w, r)
+		if w.Code != c.statusCode || !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("case %v: unexpected response %v: %.100s", i, w.Code, w.Body)
+		}
+	}
+	if _, found := http.DefaultServeMux.Handler(
+		httptest.NewRequest("GET", "/debug/pprof/", nil)); found != "" {
+		t.Errorf("pprof must not be registered on http.DefaultServeMux")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	originalLogger, originalLevel := log.GlobalLogger, log.GetLevel()
+	log.GlobalLogger = zap.New(core).Sugar()
+	defer func() {
+		log.GlobalLogger = originalLogger
+		log.SetLevel(originalLevel)
+		log.UnsetNamedLevel("kafka")
+	}()
+	kafkaLogger := log.Named("kafka")
+	consumerLogger := log.Named("kafka.consumer")
+
+	s := NewServer()
+	s.EnableAdminEndpoints(AdminConfig{IsEnableLogLevel: true})
+	for i, c := range []struct {
+		method     string
+		body       string
+		statusCode int
+		expected   LogLevelResponse
+	}{
+		{"PUT", `{"Level": "info"}`, 200,
+			LogLevelResponse{Level: "info", NamedLevels: map[string]string{}}},
+		{"PUT", `{"Name": "kafka", "Level": "WARN"}`, 200,
+			LogLevelResponse{Level: "info", NamedLevels: map[string]string{"kafka": "warn"}}},
+		{"PUT", `{"Level": "verbose"}`, 400, LogLevelResponse{}},
+		{"GET", "", 200,
+			LogLevelResponse{Level: "info", NamedLevels: map[string]string{"kafka": "warn"}}},
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(c.method, "/__log/level",
+			strings.NewReader(c.body)))
+		if w.Code != c.statusCode {
+			t.Errorf("case %v: unexpected status %v: %s", i, w.Code, w.Body)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+		var res LogLevelResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if !reflect.DeepEqual(res, c.expected) {
+			t.Errorf("case %v: expected %+v, got %+v", i, c.expected, res)
+		}
+	}
+
+	logs.TakeAll()
+	kafkaLogger.Info("kafka info")
+	kafkaLogger.Warn("kafka warn")
+	consumerLogger.Info("consumer info")
+	log.Named("websocket").Debug("websocket debug")
+	log.Named("websocket").Info("websocket info")
+	var messages []string
+	for _, entry := range logs.TakeAll() {
+		messages = append(messages, entry.Message)
+	}
+	expected := []string{"kafka warn", "websocket info"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected logged %v, got %v", expected, messages)
+	}
+
+	log.UnsetNamedLevel("kafka")
+	consumerLogger.Info("consumer info")
+	if n := logs.Len(); n != 1 {
+		t.Errorf("named logger must follow the root level after unset, logged %v", n)
+	}
+
+	secured := NewServer()
+	secured.EnableAdminEndpoints(AdminConfig{IsEnableLogLevel: true,
+		AdminUsername: "admin", AdminPassword: "secret"})
+	for i, password := range []string{"", "wrong"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "/__log/level", strings.NewReader(`{"Level": "debug"}`))
+		if password != "" {
+			r.SetBasicAuth("admin", password)
+		}
+		secured.router.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized || log.GetLevel() != zap.InfoLevel {
+			t.Errorf("case %v: unexpected status %v, level %v", i, w.Code, log.GetLevel())
+		}
+	}
+}
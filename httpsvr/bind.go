@@ -0,0 +1,374 @@
+package httpsvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes why a field of a request is invalid
+type FieldError struct {
+	// Field is the name of the field in the request (the tag name),
+	// nested field is joined by ".", ex: "address.city"
+	Field string
+	// Rule is the failed validate rule, ex: "required", "min",
+	// Rule is "type" if the input value cannot be parsed to the field type
+	Rule    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%v %v", e.Field, e.Message)
+}
+
+// Bind reads URL params, query string, headers and JSON body of the request
+// to outPtr (a pointer to a struct) base on struct tags, then validates it.
+// Example:
+//
+//	type UpdateUserRequest struct {
+//		Id    int64  `path:"id" validate:"required"`
+//		Limit int    `query:"limit" validate:"omitempty,min=1,max=100"`
+//		Token string `header:"X-Token"`
+//		Email string `json:"email" validate:"required,email"`
+//	}
+//
+// Supported validate rules: required, omitempty, min, max, len, email, oneof
+// (min, max and len compare numbers or length of strings, slices, maps).
+// The returned error is an *Error with Code 400 and Details is a []FieldError,
+// it can be responded directly by WriteError. An invalid validate tag is not
+// an *Error, so it will be responded as a internal server error.
+func (s Server) Bind(r *http.Request, outPtr interface{}) error {
+	rv := reflect.ValueOf(outPtr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errBindNonStructPointer
+	}
+	body, err := s.readBody(r)
+	if err != nil {
+		return &Error{Code: http.StatusBadRequest,
+			Message: fmt.Sprintf("cannot read body: %v", err)}
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		err := json.Unmarshal(body, outPtr)
+		if err != nil {
+			return &Error{Code: http.StatusBadRequest,
+				Message: fmt.Sprintf("invalid JSON body: %v", err)}
+		}
+	}
+	src := bindSource{params: GetUrlParams(r), query: r.URL.Query(), header: r.Header}
+	var fieldErrs []FieldError
+	bindStruct(rv.Elem(), src, &fieldErrs)
+	validateErrs, err := validateStruct(rv.Elem(), "")
+	if err != nil {
+		return err
+	}
+	fieldErrs = append(fieldErrs, validateErrs...)
+	if len(fieldErrs) > 0 {
+		return &Error{Code: http.StatusBadRequest, Message: "invalid request",
+			Details: fieldErrs}
+	}
+	return nil
+}
+
+func Bind(r *http.Request, outPtr interface{}) error {
+	return emptyServer.Bind(r, outPtr)
+}
+
+// Validate checks validate tags of a struct (or a pointer to a struct),
+// returns nil if obj is valid. Example tag: `validate:"required,max=64"`.
+// It panics if a validate tag is invalid.
+func Validate(obj interface{}) []FieldError {
+	rv := reflect.ValueOf(obj)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	fieldErrs, err := validateStruct(rv, "")
+	if err != nil {
+		panic(err)
+	}
+	return fieldErrs
+}
+
+var errBindNonStructPointer = errors.New("bind output must be a pointer to a struct")
+
+// bindSource gets input values by a tag name
+type bindSource struct {
+	params map[string]string
+	query  map[string][]string
+	header http.Header
+}
+
+func (src bindSource) get(tag string, name string) []string {
+	switch tag {
+	case "path":
+		if v, found := src.params[name]; found {
+			return []string{v}
+		}
+	case "query":
+		return src.query[name]
+	case "header":
+		return src.header[http.CanonicalHeaderKey(name)]
+	}
+	return nil
+}
+
+var bindTags = []string{"path", "query", "header"}
+
+func bindStruct(v reflect.Value, src bindSource, fieldErrs *[]FieldError) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		fv := v.Field(i)
+		if field.Anonymous && fv.Kind() == reflect.Struct {
+			bindStruct(fv, src, fieldErrs)
+			continue
+		}
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		for _, tag := range bindTags {
+			name := field.Tag.Get(tag)
+			if name == "" || name == "-" {
+				continue
+			}
+			values := src.get(tag, name)
+			if len(values) == 0 {
+				continue
+			}
+			err := setField(fv, values)
+			if err != nil {
+				*fieldErrs = append(*fieldErrs, FieldError{Field: name,
+					Rule: "type", Message: err.Error()})
+			}
+		}
+	}
+}
+
+func setField(fv reflect.Value, values []string) error {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(fv.Type().Elem())
+		err := setField(elem.Elem(), values)
+		if err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			err := setScalar(slice.Index(i), value)
+			if err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	default:
+		return setScalar(fv, values[0])
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setScalar(fv reflect.Value, value string) error {
+	var err error
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if fv.Type() == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			n = int64(d)
+		} else {
+			n, err = strconv.ParseInt(value, 10, fv.Type().Bits())
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(value, 10, fv.Type().Bits())
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, fv.Type().Bits())
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("has unsupported type %v", fv.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("cannot parse %q as %v", value, fv.Type())
+	}
+	return nil
+}
+
+// fieldName returns the name of the field in the request
+func fieldName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	for _, tag := range bindTags {
+		if name := field.Tag.Get(tag); name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// validateStruct returns an error if a validate tag is invalid
+func validateStruct(v reflect.Value, prefix string) ([]FieldError, error) {
+	var fieldErrs []FieldError
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		fv := v.Field(i)
+		if field.Anonymous && fv.Kind() == reflect.Struct {
+			errs, err := validateStruct(fv, prefix)
+			if err != nil {
+				return nil, err
+			}
+			fieldErrs = append(fieldErrs, errs...)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := prefix + fieldName(field)
+		if rules := field.Tag.Get("validate"); rules != "" && rules != "-" {
+			errs, err := validateValue(fv, name, rules)
+			if err != nil {
+				return nil, fmt.Errorf("field %v: %v", field.Name, err)
+			}
+			fieldErrs = append(fieldErrs, errs...)
+		}
+		inner := fv
+		if inner.Kind() == reflect.Ptr && !inner.IsNil() {
+			inner = inner.Elem()
+		}
+		if inner.Kind() == reflect.Struct && inner.Type() != timeType {
+			errs, err := validateStruct(inner, name+".")
+			if err != nil {
+				return nil, err
+			}
+			fieldErrs = append(fieldErrs, errs...)
+		}
+	}
+	return fieldErrs, nil
+}
+
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// validateValue returns an error if rules are invalid, ex: an unknown rule or
+// a min argument is not a number
+func validateValue(fv reflect.Value, name string, rules string) ([]FieldError, error) {
+	var fieldErrs []FieldError
+	isZero := reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface())
+	if fv.Kind() == reflect.Ptr && !fv.IsNil() {
+		fv = fv.Elem()
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		key, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, arg = rule[:i], rule[i+1:]
+		}
+		fail := func(msg string, args ...interface{}) {
+			fieldErrs = append(fieldErrs, FieldError{Field: name, Rule: key,
+				Message: fmt.Sprintf(msg, args...)})
+		}
+		switch key {
+		case "":
+			continue
+		case "omitempty":
+			if isZero {
+				return fieldErrs, nil
+			}
+			continue
+		case "required":
+			if isZero {
+				fail("is required")
+				return fieldErrs, nil
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Ptr { // nil pointer only checked by required
+			continue
+		}
+		switch key {
+		case "min", "max", "len":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validate rule %q", rule)
+			}
+			size, isLength, ok := measure(fv)
+			if !ok {
+				return nil, fmt.Errorf("unsupported type %v for validate rule %v",
+					fv.Type(), key)
+			}
+			what := "must be"
+			if isLength {
+				what = "length must be"
+			}
+			switch {
+			case key == "min" && size < limit:
+				fail("%v at least %v", what, arg)
+			case key == "max" && size > limit:
+				fail("%v at most %v", what, arg)
+			case key == "len" && size != limit:
+				fail("%v %v", what, arg)
+			}
+		case "email":
+			if fv.Kind() != reflect.String || !emailRegexp.MatchString(fv.String()) {
+				fail("must be a valid email")
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			value := fmt.Sprintf("%v", fv.Interface())
+			isFound := false
+			for _, option := range options {
+				if option == value {
+					isFound = true
+					break
+				}
+			}
+			if !isFound {
+				fail("must be one of %v", options)
+			}
+		default:
+			return nil, fmt.Errorf("unknown validate rule %q", key)
+		}
+	}
+	return fieldErrs, nil
+}
+
+// measure returns value of a number or length of a string, slice, map
+func measure(fv reflect.Value) (size float64, isLength bool, ok bool) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(fv.Len()), true, true
+	}
+	return 0, false, false
+}
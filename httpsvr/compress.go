@@ -0,0 +1,251 @@
+package httpsvr
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressMinSize is used if CompressConfig_MinSize is not set
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressContentTypes is used if CompressConfig_ContentTypes is not set
+var DefaultCompressContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressConfig configs CompressMiddleware
+type CompressConfig struct {
+	// MinSize is the min body size in bytes to compress,
+	// default DefaultCompressMinSize. A streaming response (the handler
+	// called Flush) is compressed regardless of its size.
+	MinSize int
+	// ContentTypes are prefixes of compressible response Content-Type,
+	// default DefaultCompressContentTypes
+	ContentTypes []string
+	// Level is the compression level, default gzip_DefaultCompression
+	Level int
+}
+
+// CompressMiddleware compresses responses by gzip or deflate (negotiated by
+// the request header Accept-Encoding), responses that already have a
+// Content-Encoding (ex: precompressed static files) are not compressed.
+// Range requests and partial responses are not compressed because
+// Content-Range counts bytes of the uncompressed body.
+// If it is added after the LogMiddleware, the logged size is the compressed size.
+func CompressMiddleware(conf CompressConfig) Middleware {
+	if conf.MinSize <= 0 {
+		conf.MinSize = DefaultCompressMinSize
+	}
+	if len(conf.ContentTypes) == 0 {
+		conf.ContentTypes = DefaultCompressContentTypes
+	}
+	if conf.Level == 0 || conf.Level < gzip.HuffmanOnly || conf.Level > gzip.BestCompression {
+		conf.Level = gzip.DefaultCompression
+	}
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, conf.Level)
+			return w
+		}},
+		"deflate": {New: func() interface{} {
+			// HTTP deflate coding is the zlib format, not raw deflate
+			w, _ := zlib.NewWriterLevel(nil, conf.Level)
+			return w
+		}},
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				next(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Header.Get("Range") != "" {
+				next(w, r)
+				return
+			}
+			encoding := ""
+			acceptEncoding := r.Header.Get("Accept-Encoding")
+			for _, e := range []string{"gzip", "deflate"} {
+				if isAcceptedEncoding(acceptEncoding, e) {
+					encoding = e
+					break
+				}
+			}
+			if encoding == "" {
+				next(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, conf: conf,
+				encoding: encoding, pool: pools[encoding]}
+			defer cw.close()
+			next(cw, r)
+		}
+	}
+}
+
+// resetWriteCloser is implemented by gzip_Writer and zlib_Writer
+type resetWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter buffers the response until MinSize bytes were written
+// to decide whether to compress
+type compressWriter struct {
+	http.ResponseWriter
+	conf       CompressConfig
+	encoding   string
+	pool       *sync.Pool
+	status     int
+	buf        []byte
+	isDecided  bool
+	compressor resetWriteCloser // nil if the response is not compressed
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	if w.isDecided || statusCode < 200 { // informational headers are sent immediately
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.isDecided {
+		if w.compressor != nil {
+			return w.compressor.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < w.conf.MinSize {
+		return len(b), nil
+	}
+	w.decide(false)
+	return len(b), w.writeBuf()
+}
+
+// decide writes the header, do not call this func twice
+func (w *compressWriter) decide(isStreaming bool) {
+	w.isDecided = true
+	if w.shouldCompress(isStreaming) {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// the compressed body is not byte-for-byte same as the original
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.compressor = w.pool.Get().(resetWriteCloser)
+		w.compressor.Reset(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *compressWriter) shouldCompress(isStreaming bool) bool {
+	if w.status == http.StatusNoContent || w.status == http.StatusNotModified ||
+		w.status == http.StatusPartialContent {
+		return false
+	}
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if !isStreaming && len(w.buf) < w.conf.MinSize {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		if len(w.buf) == 0 {
+			return false
+		}
+		// same as net/http does when the handler did not set Content-Type
+		contentType = http.DetectContentType(w.buf)
+		h.Set("Content-Type", contentType)
+	}
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range w.conf.ContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *compressWriter) writeBuf() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.compressor != nil {
+		_, err = w.compressor.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush implements http_Flusher, the response is compressed regardless of
+// MinSize because the handler is streaming
+func (w *compressWriter) Flush() {
+	if !w.isDecided {
+		w.decide(true)
+		w.writeBuf()
+	}
+	if w.compressor != nil {
+		w.compressor.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http_Hijacker
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	w.isDecided = true
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer, used by http_ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close writes the buffered body and the compression footer
+func (w *compressWriter) close() {
+	if !w.isDecided {
+		w.decide(false)
+		w.writeBuf()
+	}
+	if w.compressor != nil {
+		w.compressor.Close()
+		w.compressor.Reset(nil)
+		w.pool.Put(w.compressor)
+		w.compressor = nil
+	}
+}
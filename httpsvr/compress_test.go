@@ -0,0 +1,152 @@
+package httpsvr
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	bigJson := map[string]string{"Data": strings.Repeat("PONG", 1000)}
+	var captured *ResponseWriter
+	s := NewServer()
+	s.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			captured = NewResponseWriter(w)
+			next(captured, r)
+		}
+	}, CompressMiddleware(CompressConfig{}))
+	s.AddHandler("GET", "/big", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		s.WriteJson(w, r, bigJson)
+	})
+	s.AddHandler("GET", "/small", ExampleHandler())
+	s.AddHandler("GET", "/png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 2048))
+	})
+	s.AddHandler("GET", "/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: 2\n\n"))
+	})
+
+	do := func(path string, acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/big", "gzip, deflate")
+	if w.Code != http.StatusAccepted || w.Header().Get("Content-Encoding") != "gzip" ||
+		w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %v: %v", w.Code, w.Header())
+	}
+	compressedSize := w.Body.Len()
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(gr)
+	if !strings.Contains(string(body), "PONGPONG") || compressedSize >= len(body) {
+		t.Errorf("unexpected body: %v bytes, compressed %v", len(body), compressedSize)
+	}
+	if captured.Status() != http.StatusAccepted || captured.Size() != compressedSize {
+		t.Errorf("unexpected captured: %v %v", captured.Status(), captured.Size())
+	}
+
+	w = do("/big", "deflate")
+	zr, err := zlib.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(zr)
+	if w.Header().Get("Content-Encoding") != "deflate" || !strings.Contains(string(body), "PONG") {
+		t.Errorf("unexpected deflate response: %v", w.Header())
+	}
+	if w := do("/big", ""); w.Header().Get("Content-Encoding") != "" ||
+		!strings.Contains(w.Body.String(), "PONG") {
+		t.Errorf("encoding was not accepted but %v", w.Header())
+	}
+	if w := do("/small", "gzip"); w.Header().Get("Content-Encoding") != "" ||
+		!strings.Contains(w.Body.String(), "PONG") {
+		t.Errorf("small response should not be compressed: %v", w.Header())
+	}
+	if w := do("/png", "gzip"); w.Header().Get("Content-Encoding") != "" ||
+		w.Body.Len() != 2048 {
+		t.Errorf("image should not be compressed: %v", w.Header())
+	}
+
+	w = do("/stream", "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || !w.Flushed {
+		t.Fatalf("unexpected stream response: %v", w.Header())
+	}
+	gr, err = gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(gr)
+	if string(body) != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("unexpected stream body: %q", body)
+	}
+}
+
+func TestCompressStaticRange(t *testing.T) {
+	tmpDir, dir := newStaticDir(t)
+	defer os.RemoveAll(tmpDir)
+	content := strings.Repeat("0123456789", 500)
+	ioutil.WriteFile(filepath.Join(dir, "big.txt"), []byte(content), 0600)
+	s := NewServer()
+	s.Use(CompressMiddleware(CompressConfig{}))
+	s.ServeDir("/static", dir)
+	s.AddHandler("GET", "/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Range", "bytes 0-1999/5000")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(content[:2000]))
+	})
+	do := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/static/big.txt", map[string]string{"Range": "bytes=0-1999"})
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "" ||
+		w.Header().Get("Content-Range") != "bytes 0-1999/5000" ||
+		w.Body.String() != content[:2000] {
+		t.Errorf("range response must not be compressed: %v: %v", w.Code, w.Header())
+	}
+	if w := do("/partial", nil); w.Header().Get("Content-Encoding") != "" ||
+		w.Body.String() != content[:2000] {
+		t.Errorf("partial response must not be compressed: %v", w.Header())
+	}
+
+	w = do("/static/big.txt", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.HasPrefix(etag, `W/"`) || w.Header().Get("Accept-Ranges") != "" {
+		t.Fatalf("unexpected compressed response %v: %v", w.Code, w.Header())
+	}
+	if w := do("/static/big.txt", map[string]string{"If-None-Match": etag}); w.Code != 304 {
+		t.Errorf("expected 304 but %v", w.Code)
+	}
+	if w := do("/static/big.txt", map[string]string{"Accept-Encoding": ""}); w.Header().Get("ETag") != etag[2:] {
+		t.Errorf("uncompressed response must have the strong ETag, got %v",
+			w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,206 @@
+package httpsvr
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CORSConfig is a Cross-Origin Resource Sharing policy
+type CORSConfig struct {
+	// AllowOrigins can contain:
+	// "*" allows all origins,
+	// an origin with wildcards, ex: "https://*.example.com",
+	// a regular expression wrapped by "/", ex: "/^https://app[0-9]+\.example\.com$/",
+	// or an exact origin, ex: "https://example.com"
+	AllowOrigins []string
+	// AllowMethods default is the methods registered for the path by AddHandler
+	AllowMethods []string
+	// AllowHeaders default is the headers requested by the preflight request
+	AllowHeaders []string
+	// ExposeHeaders are headers that browsers allow scripts to read
+	ExposeHeaders []string
+	// IsAllowCredentials allows cookies and HTTP authentication,
+	// it cannot be used with AllowOrigins "*"
+	IsAllowCredentials bool
+	// MaxAge is how long the preflight response can be cached, 0 is not set
+	MaxAge time.Duration
+}
+
+// SetCORS sets the global CORS policy, it can be overridden by Group_SetCORS
+// or Server_SetRouteCORS.
+// OPTIONS requests to registered paths are handled automatically
+// (without middlewares, so authentication middlewares do not block preflight).
+func (s *Server) SetCORS(conf CORSConfig) error {
+	return s.root.SetCORS(conf)
+}
+
+// SetCORS sets the CORS policy for routes of this group and sub groups
+func (g *Group) SetCORS(conf CORSConfig) error {
+	policy, err := newCORSPolicy(conf)
+	if err != nil {
+		return err
+	}
+	g.mutex.Lock()
+	g.cors = policy
+	g.mutex.Unlock()
+	return nil
+}
+
+// SetRouteCORS sets the CORS policy for a path, path is the full pattern
+// (included group prefix), ex: "/admin/users/:id"
+func (s *Server) SetRouteCORS(path string, conf CORSConfig) error {
+	policy, err := newCORSPolicy(conf)
+	if err != nil {
+		return err
+	}
+	s.routes.mutex.Lock()
+	s.routes.corsPolicies[path] = policy
+	s.routes.mutex.Unlock()
+	return nil
+}
+
+// policy returns the CORS policy of a route: the route policy,
+// then the nearest group policy, returns nil if no policy was set
+func (s *Server) corsPolicy(g *Group, path string) *corsPolicy {
+	s.routes.mutex.RLock()
+	policy := s.routes.corsPolicies[path]
+	s.routes.mutex.RUnlock()
+	if policy != nil {
+		return policy
+	}
+	for ; g != nil; g = g.parent {
+		g.mutex.RLock()
+		policy = g.cors
+		g.mutex.RUnlock()
+		if policy != nil {
+			return policy
+		}
+	}
+	return nil
+}
+
+// handlePreflight responds OPTIONS requests to path
+func (s *Server) handlePreflight(g *Group, path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		methods := append(s.routes.methods(path), http.MethodOptions)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		policy := s.corsPolicy(g, path)
+		if policy != nil && r.Header.Get("Access-Control-Request-Method") != "" {
+			policy.writePreflight(w, r, methods)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type corsPolicy struct {
+	conf          CORSConfig
+	isAllowAll    bool
+	origins       map[string]bool
+	originRegexps []*regexp.Regexp
+}
+
+func newCORSPolicy(conf CORSConfig) (*corsPolicy, error) {
+	p := &corsPolicy{conf: conf, origins: make(map[string]bool)}
+	for _, origin := range conf.AllowOrigins {
+		switch {
+		case origin == "*":
+			p.isAllowAll = true
+		case len(origin) > 1 && strings.HasPrefix(origin, "/") &&
+			strings.HasSuffix(origin, "/"):
+			re, err := regexp.Compile(origin[1 : len(origin)-1])
+			if err != nil {
+				return nil, fmt.Errorf("error parse CORS origin %v: %v", origin, err)
+			}
+			p.originRegexps = append(p.originRegexps, re)
+		case strings.Contains(origin, "*"):
+			pattern := strings.Replace(regexp.QuoteMeta(origin),
+				`\*`, `[a-zA-Z0-9.-]*`, -1)
+			p.originRegexps = append(p.originRegexps,
+				regexp.MustCompile("^"+pattern+"$"))
+		default:
+			p.origins[origin] = true
+		}
+	}
+	if p.isAllowAll && conf.IsAllowCredentials {
+		return nil, fmt.Errorf("CORS AllowOrigins \"*\" cannot be used with " +
+			"IsAllowCredentials, list the allowed origins instead")
+	}
+	return p, nil
+}
+
+func (p *corsPolicy) isAllowedOrigin(origin string) bool {
+	if p.isAllowAll || p.origins[origin] {
+		return true
+	}
+	for _, re := range p.originRegexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOrigin writes headers that are common for actual and preflight
+// responses, returns false if the origin is not allowed
+func (p *corsPolicy) writeOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !p.isAllowedOrigin(origin) {
+		return false
+	}
+	if p.isAllowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.conf.IsAllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// writeActual writes CORS headers of a non preflight response
+func (p *corsPolicy) writeActual(w http.ResponseWriter, r *http.Request) {
+	if !p.writeOrigin(w, r) {
+		return
+	}
+	if len(p.conf.ExposeHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers",
+			strings.Join(p.conf.ExposeHeaders, ", "))
+	}
+}
+
+func (p *corsPolicy) writePreflight(w http.ResponseWriter, r *http.Request,
+	routeMethods []string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	allowMethods := p.conf.AllowMethods
+	if len(allowMethods) == 0 {
+		allowMethods = routeMethods
+	}
+	reqMethod := r.Header.Get("Access-Control-Request-Method")
+	isAllowedMethod := false
+	for _, method := range allowMethods {
+		if strings.EqualFold(method, reqMethod) {
+			isAllowedMethod = true
+			break
+		}
+	}
+	if !isAllowedMethod || !p.writeOrigin(w, r) {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowMethods, ", "))
+	if len(p.conf.AllowHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers",
+			strings.Join(p.conf.AllowHeaders, ", "))
+	} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if p.conf.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age",
+			fmt.Sprintf("%v", int(p.conf.MaxAge.Seconds())))
+	}
+}
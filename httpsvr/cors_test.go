@@ -0,0 +1,111 @@
+package httpsvr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	s := NewServer()
+	denyAll := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, r, NewError(http.StatusUnauthorized, "need token"))
+		}
+	}
+	s.AddHandler("GET", "/users/:id", ExampleHandler())
+	s.AddHandler("PUT", "/users/:id", ExampleHandler())
+	admin := s.Group("/admin", denyAll)
+	admin.AddHandler("POST", "/jobs", ExampleHandler())
+	s.AddHandler("GET", "/public", ExampleHandler())
+	err := s.SetCORS(CORSConfig{
+		AllowOrigins: []string{"https://*.example.com", `/^http://localhost:[0-9]+$/`},
+		MaxAge:       time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.SetCORS(CORSConfig{AllowOrigins: []string{"https://admin.example.com"},
+		IsAllowCredentials: true, AllowHeaders: []string{"Authorization"}})
+	s.SetRouteCORS("/public", CORSConfig{AllowOrigins: []string{"*"}})
+
+	for i, c := range []struct {
+		method      string
+		path        string
+		origin      string
+		reqMethod   string
+		statusCode  int
+		allowOrigin string
+		allowMethod string
+	}{
+		{"OPTIONS", "/users/1", "https://app.example.com", "PUT", 204,
+			"https://app.example.com", "GET, PUT, OPTIONS"},
+		{"OPTIONS", "/users/1", "http://localhost:3000", "DELETE", 204, "", ""},
+		{"OPTIONS", "/users/1", "https://evil.com", "GET", 204, "", ""},
+		{"GET", "/users/1", "http://localhost:3000", "", 200, "http://localhost:3000", ""},
+		{"GET", "/users/1", "http://localhost:abc", "", 200, "", ""},
+		{"OPTIONS", "/admin/jobs", "https://admin.example.com", "POST", 204,
+			"https://admin.example.com", "POST, OPTIONS"},
+		{"POST", "/admin/jobs", "https://admin.example.com", "", 401,
+			"https://admin.example.com", ""},
+		{"POST", "/admin/jobs", "https://app.example.com", "", 401, "", ""},
+		{"GET", "/public", "https://any.com", "", 200, "*", ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, nil)
+		r.Header.Set("Origin", c.origin)
+		if c.reqMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", c.reqMethod)
+		}
+		s.router.ServeHTTP(w, r)
+		h := w.Header()
+		if w.Code != c.statusCode ||
+			h.Get("Access-Control-Allow-Origin") != c.allowOrigin ||
+			h.Get("Access-Control-Allow-Methods") != c.allowMethod {
+			t.Errorf("case %v: unexpected response %v: %v", i, w.Code, h)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("OPTIONS", "/admin/jobs", nil)
+	r.Header.Set("Origin", "https://admin.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	s.router.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Authorization" ||
+		w.Header().Get("Access-Control-Max-Age") != "" {
+		t.Errorf("unexpected group preflight headers: %v", w.Header())
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("OPTIONS", "/users/1", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	s.router.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("unexpected global preflight headers: %v", w.Header())
+	}
+
+	if s.SetCORS(CORSConfig{AllowOrigins: []string{"/[/"}}) == nil {
+		t.Error("expected error when parse invalid regexp")
+	}
+	if s.SetCORS(CORSConfig{AllowOrigins: []string{"*"},
+		IsAllowCredentials: true}) == nil {
+		t.Error("expected error when allow all origins with credentials")
+	}
+}
+
+func TestCustomOptionsAfterOtherMethods(t *testing.T) {
+	s := NewServer()
+	s.AddHandler("GET", "/items", ExampleHandler())
+	s.AddHandler("OPTIONS", "/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/items", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("the custom OPTIONS handler must replace the automatic one, got %v",
+			w.Code)
+	}
+}
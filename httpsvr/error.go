@@ -0,0 +1,112 @@
+package httpsvr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/daominah/gomicrokit/log"
+	"github.com/daominah/gomicrokit/metric"
+)
+
+// Error is a typed error that will be responded as JSON by WriteError,
+// example body: {"Error":{"Code":404,"Message":"user not found","Details":null}}
+type Error struct {
+	// Code is the HTTP status code of the response
+	Code    int
+	Message string
+	// Details is optional data that helps client handle the error
+	Details interface{}
+}
+
+// NewError returns an Error with nil Details
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error implements error interface
+func (e Error) Error() string {
+	return fmt.Sprintf("%v %v", e.Code, e.Message)
+}
+
+// ErrorResponse is the envelope of all error responses
+type ErrorResponse struct {
+	Error Error
+}
+
+// toError converts err to an Error, non Error err is a internal server error
+func toError(err error) Error {
+	switch v := err.(type) {
+	case *Error:
+		if v != nil {
+			return *v
+		}
+	case Error:
+		return v
+	}
+	if err == nil {
+		return Error{Code: http.StatusInternalServerError,
+			Message: http.StatusText(http.StatusInternalServerError)}
+	}
+	return Error{Code: http.StatusInternalServerError, Message: err.Error()}
+}
+
+// WriteError responds an ErrorResponse as JSON, status code is Error_Code,
+// err that is not an Error will be responded as a internal server error
+func (s Server) WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	e := toError(err)
+	if e.Code == 0 {
+		e.Code = http.StatusInternalServerError
+	}
+	bodyB, mErr := json.Marshal(ErrorResponse{Error: e})
+	if mErr != nil { // Details is not marshallable
+		e.Details = nil
+		bodyB, _ = json.Marshal(ErrorResponse{Error: e})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	_, wErr := w.Write(bodyB)
+	if wErr != nil {
+		log.Condf(s.isEnableLog, "error when http respond %v: %v",
+			GetRequestId(r), wErr)
+		return
+	}
+	log.Condf(s.isEnableLog, "http respond error %v: %s", GetRequestId(r), bodyB)
+}
+
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	emptyServer.WriteError(w, r, err)
+}
+
+// RecoverMiddleware recovers a panic in the handler, logs the panic with
+// the request id, counts it in m (key "path_method_panic", m can be nil)
+// and responds a internal server error JSON.
+// NewServer adds a RecoverMiddleware that respects the server's isEnableLog.
+func RecoverMiddleware(m metric.Metric) Middleware {
+	return emptyServer.recoverMiddleware(m)
+}
+
+func (s *Server) recoverMiddleware(m metric.Metric) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler { // net/http handles this panic
+					panic(p)
+				}
+				log.Condf(s.isEnableLog, "panic when http handle %v: %v\n%s",
+					GetRequestId(r), p, debug.Stack())
+				if m != nil {
+					m.Count(GetRoute(r).MetricKey() + "_panic")
+				}
+				s.WriteError(w, r, NewError(http.StatusInternalServerError,
+					http.StatusText(http.StatusInternalServerError)))
+			}()
+			next(w, r)
+		}
+	}
+}
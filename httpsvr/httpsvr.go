@@ -3,15 +3,13 @@
 package httpsvr
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"strings"
 	"time"
 
-	"github.com/daominah/gomicrokit/gofast"
 	"github.com/daominah/gomicrokit/log"
 	"github.com/daominah/gomicrokit/metric"
 	"github.com/julienschmidt/httprouter"
@@ -32,23 +30,22 @@ type Server struct {
 	// default NewServer set isEnableMetric = true
 	isEnableMetric bool
 	Metric         metric.Metric
+	// root is the group of all routes, its middlewares are global middlewares
+	root *Group
+	// ShutdownTimeout is the max duration Run waits for in-flight requests,
+	// default DefaultShutdownTimeout
+	ShutdownTimeout time.Duration
+	// RequestIdHeader is the header that holds the request id of incoming
+	// requests and responses, default DefaultRequestIdHeader
+	RequestIdHeader string
+	lifecycle       *lifecycle
+	routes          *routeRegistry
 }
 
 // NewServer returns a inited Server,
 // for more configs, use NewServerWithConf instead of this func
 func NewServer() *Server {
-	router := httprouter.New()
-	config := NewDefaultConfig()
-	config.Handler = router
-	s := &Server{
-		config:         config,
-		isEnableLog:    true,
-		isEnableMetric: true,
-		router:         router,
-		Metric:         metric.NewMemoryMetric(),
-	}
-	s.AddHandler("GET", "/__metric", s.handleMetric())
-	return s
+	return NewServerWithConf(nil, true, true, nil)
 }
 
 // NewServerWithConf returns a inited Server from input args.
@@ -66,66 +63,80 @@ func NewServerWithConf(config *http.Server, isEnableLog bool,
 	router := httprouter.New()
 	config.Handler = router
 	s := &Server{
-		config:         config,
-		isEnableLog:    isEnableLog,
-		isEnableMetric: isEnableMetric,
-		router:         router,
-		Metric:         metric0,
+		config:          config,
+		isEnableLog:     isEnableLog,
+		isEnableMetric:  isEnableMetric,
+		router:          router,
+		Metric:          metric0,
+		RequestIdHeader: DefaultRequestIdHeader,
+		lifecycle:       newLifecycle(),
+		routes:          newRouteRegistry(),
 	}
+	s.initMiddlewares()
 	s.AddHandler("GET", "/__metric", s.handleMetric())
+	s.AddHandler("GET", "/__metrics/prometheus", s.handlePrometheus())
 	return s
 }
 
-// AddHandler must be called before ListenAndServe,
-// ex: AddHandler("GET", "/", ExampleHandler()).
-func (s *Server) AddHandler(method string, path string, handler http.HandlerFunc) {
-	defer func() { // in case of adding a same handler twice
-		if r := recover(); r != nil {
-			log.Infof("error when AddHandler: %v", r)
-		}
-	}()
-	// be careful with augmenting handler, example stack overflow:
-	// 	f := func() { log.Println("f called") }
-	//	f = func() { f() }
-	//	f()
-
-	var augmented1 http.HandlerFunc
-	if !s.isEnableMetric {
-		augmented1 = handler
-	} else {
-		metricKey := fmt.Sprintf("%v_%v", path, method)
-		augmented1 = func(w http.ResponseWriter, r *http.Request) {
-			s.Metric.Count(metricKey)
-			beginTime := time.Now()
-			handler(w, r)
-			s.Metric.Duration(metricKey, time.Since(beginTime))
-		}
+// initMiddlewares inits the root group with the built-in middlewares:
+// RequestIdMiddleware, LogMiddleware, RecoverMiddleware then MetricMiddleware
+// (LogMiddleware and MetricMiddleware depend on isEnableLog, isEnableMetric)
+func (s *Server) initMiddlewares() {
+	s.root = newGroup(s, nil, "", nil)
+	s.root.Use(s.requestIdMiddleware())
+	if s.isEnableLog {
+		s.root.Use(LogMiddleware())
 	}
-
-	var augmented2 http.HandlerFunc
-	if !s.isEnableLog {
-		augmented2 = augmented1
+	if s.isEnableMetric {
+		s.root.Use(s.recoverMiddleware(s.Metric), MetricMiddleware(s.Metric))
 	} else {
-		augmented2 = func(w http.ResponseWriter, r *http.Request) {
-			requestId := gofast.GenUUID()
-			ctx := context.WithValue(r.Context(), CtxRequestId, requestId)
-			query := r.URL.Query().Encode()
-			if query != "" {
-				query = "?" + query
-			}
-			log.Condf(s.isEnableLog, "http request %v from %v: %v %v%v",
-				requestId, r.RemoteAddr, r.Method, r.URL.Path, query)
-			augmented1(w, r.WithContext(ctx))
-			log.Condf(s.isEnableLog, "http responded %v to %v: %v %v%v",
-				requestId, r.RemoteAddr, r.Method, r.URL.Path, query)
-		}
+		s.root.Use(s.recoverMiddleware(nil))
 	}
+}
+
+// AddHandler must be called before ListenAndServe,
+// ex: AddHandler("GET", "/", ExampleHandler()).
+// Optional routeMws only wrap this handler, they are called after
+// the global middlewares (added by Use).
+func (s *Server) AddHandler(method string, path string, handler http.HandlerFunc,
+	routeMws ...Middleware) {
+	s.root.AddHandler(method, path, handler, routeMws...)
+}
+
+// Use appends global middlewares, they wrap all handlers of the server,
+// the first middleware is the outermost. NewServer already added
+// RequestIdMiddleware, LogMiddleware, RecoverMiddleware and MetricMiddleware,
+// call ResetMiddlewares to change the order.
+func (s *Server) Use(mws ...Middleware) {
+	s.root.Use(mws...)
+}
+
+// ResetMiddlewares replaces all global middlewares by input mws,
+// ex: s.ResetMiddlewares(LogMiddleware(), auth, MetricMiddleware(s.Metric)),
+// without RecoverMiddleware, a panic in a handler will be handled by net/http.
+func (s *Server) ResetMiddlewares(mws ...Middleware) {
+	s.root.mutex.Lock()
+	s.root.middlewares = append([]Middleware{}, mws...)
+	s.root.mutex.Unlock()
+	s.routes.middlewaresChanged()
+}
+
+// Group returns a group of routes that have a same path prefix and
+// middlewares, ex: s.Group("/admin", requireJWT).AddHandler("GET", "/users", h)
+func (s *Server) Group(prefix string, mws ...Middleware) *Group {
+	return s.root.Group(prefix, mws...)
+}
 
-	s.router.HandlerFunc(method, path, augmented2)
+// ServeHTTP implements http_Handler by the router of all routes, so a Server
+// can be served in-process, ex: httptest_NewServer(s), see package httptestkit
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
 }
 
 // ListenAndServe listens on the TCP network address addr.
 // Accepted connections are configured to enable TCP keep-alives.
+// After Shutdown, this func returns http_ErrServerClosed immediately,
+// use Run for a graceful shutdown on SIGTERM.
 func (s *Server) ListenAndServe(addr string) error {
 	s.config.Addr = addr
 	return s.config.ListenAndServe()
@@ -143,7 +154,7 @@ func (s Server) WriteJson(w http.ResponseWriter, r *http.Request, obj interface{
 	if err != nil {
 		log.Condf(s.isEnableLog, "error when http respond %v: %v",
 			GetRequestId(r), err)
-		http.Error(w, err.Error(), 500)
+		s.WriteError(w, r, NewError(http.StatusInternalServerError, err.Error()))
 		return 0, err
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -159,15 +170,27 @@ func (s Server) WriteJson(w http.ResponseWriter, r *http.Request, obj interface{
 
 // ReadJson reads http request body to outPtr
 func (s Server) ReadJson(r *http.Request, outPtr interface{}) error {
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := s.readBody(r)
 	if err != nil {
 		return err
 	}
-	log.Condf(s.isEnableLog, "http request body %v: %s", GetRequestId(r), body)
 	err = json.Unmarshal(body, outPtr)
 	return err
 }
 
+// readBody reads and logs http request body
+func (s Server) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Condf(s.isEnableLog, "http request body %v: %s", GetRequestId(r), body)
+	return body, nil
+}
+
 func (s Server) handleMetric() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		currentMetric := s.Metric.GetCurrentMetric()
@@ -181,6 +204,53 @@ func (s Server) handleMetric() http.HandlerFunc {
 	}
 }
 
+// handlePrometheus exposes Server_Metric in Prometheus text format,
+// metric keys "path_method" are converted to labels path and method
+func (s Server) handlePrometheus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if s.Metric == nil {
+			return
+		}
+		err := metric.WritePrometheusWithConf(w, s.Metric, metric.PrometheusConfig{
+			Name:        "http_request_duration_seconds",
+			Help:        "Count and duration of http requests",
+			KeyToLabels: metricKeyToLabels,
+		})
+		if err != nil {
+			log.Condf(s.isEnableLog, "error when http respond %v: %v",
+				GetRequestId(r), err)
+		}
+	}
+}
+
+// metricKeyToLabels converts a metric key "path_method" or "path_method_status"
+// (ex: "/match/:id_GET_panic") to labels path, method and optional status.
+// Key that does not contain a method is converted to label "key".
+func metricKeyToLabels(key string) map[string]string {
+	words := strings.Split(key, "_")
+	for i := len(words) - 1; i > 0; i-- {
+		if !httpMethods[words[i]] {
+			continue
+		}
+		labels := map[string]string{
+			"path":   strings.Join(words[:i], "_"),
+			"method": words[i],
+		}
+		if i+1 < len(words) {
+			labels["status"] = strings.Join(words[i+1:], "_")
+		}
+		return labels
+	}
+	return map[string]string{"key": key}
+}
+
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true,
+	http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+	http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
 var emptyServer = &Server{isEnableLog: true}
 
 func WriteJson(w http.ResponseWriter, r *http.Request, obj interface{}) (int, error) {
@@ -211,9 +281,11 @@ type ctxKeyType string
 // CtxRequestId is a internal request id
 const CtxRequestId ctxKeyType = "CtxRequestId"
 
-// GetRequestId returns the auto generated unique requestId
+// GetRequestId returns the request id (read from the request header or
+// generated by RequestIdMiddleware), returns "" if the request does not have
 func GetRequestId(r *http.Request) string {
-	return fmt.Sprintf("%v", r.Context().Value(CtxRequestId))
+	requestId, _ := r.Context().Value(CtxRequestId).(string)
+	return requestId
 }
 
 func ExampleHandler() http.HandlerFunc {
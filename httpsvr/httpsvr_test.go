@@ -1,11 +1,23 @@
 package httpsvr
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/daominah/gomicrokit/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestHttp(t *testing.T) {
@@ -67,3 +79,452 @@ func TestHttp(t *testing.T) {
 
 	t.Log(s.Metric.GetCurrentMetric())
 }
+
+func TestMiddleware(t *testing.T) {
+	s := NewServer()
+	var calls []string
+	newMw := func(name string) Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next(w, r)
+			}
+		}
+	}
+	stopper := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler "+GetRoute(r).Path)
+	}
+	s.Use(newMw("global"))
+	s.AddHandler("GET", "/a", handler, newMw("route"))
+	admin := s.Group("/admin", newMw("admin"))
+	admin.AddHandler("GET", "/users/:id", handler, newMw("route"))
+	admin.Group("/secret", stopper).AddHandler("GET", "/", handler)
+	admin.Use(newMw("admin2")) // affects routes added before
+
+	for i, c := range []struct {
+		path       string
+		expect     []string
+		statusCode int
+	}{
+		{"/a", []string{"global", "route", "handler /a"}, 200},
+		{"/admin/users/1", []string{"global", "admin", "admin2", "route",
+			"handler /admin/users/:id"}, 200},
+		{"/admin/secret/", []string{"global", "admin", "admin2"}, 403},
+	} {
+		calls = nil
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.statusCode {
+			t.Errorf("case %v: statusCode %v, expect %v", i, w.Code, c.statusCode)
+		}
+		if !reflect.DeepEqual(calls, c.expect) {
+			t.Errorf("case %v: calls %v, expect %v", i, calls, c.expect)
+		}
+	}
+	metricKeys := make(map[string]bool)
+	for _, row := range s.Metric.GetCurrentMetric() {
+		metricKeys[row.Key] = true
+	}
+	if !metricKeys["/admin/users/:id_GET_2xx"] || !metricKeys["/admin/secret/_GET_4xx"] {
+		t.Errorf("unexpected metric keys: %v", metricKeys)
+	}
+}
+
+func TestMiddlewareWrappedOnce(t *testing.T) {
+	s := NewServer()
+	nWraps := 0
+	counter := func(next http.HandlerFunc) http.HandlerFunc {
+		nWraps++
+		count := 0 // state of the middleware must be kept between requests
+		return func(w http.ResponseWriter, r *http.Request) {
+			count++
+			w.Header().Set("X-Count", fmt.Sprint(count))
+			next(w, r)
+		}
+	}
+	s.Use(counter)
+	s.AddHandler("GET", "/", ExampleHandler())
+	do := func() string {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Header().Get("X-Count")
+	}
+	var counts []string
+	for i := 0; i < 3; i++ {
+		counts = append(counts, do())
+	}
+	if !reflect.DeepEqual(counts, []string{"1", "2", "3"}) || nWraps != 1 {
+		t.Errorf("unexpected counts %v, wraps %v", counts, nWraps)
+	}
+
+	// the chain is rebuilt after Use
+	called := false
+	s.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			next(w, r)
+		}
+	})
+	if do(); !called || nWraps != 2 {
+		t.Errorf("middleware added after AddHandler was not called, wraps %v", nWraps)
+	}
+}
+
+func TestResetMiddlewares(t *testing.T) {
+	s := NewServer()
+	s.ResetMiddlewares()
+	s.AddHandler("GET", "/", ExampleHandler())
+	s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(s.Metric.GetCurrentMetric()) != 0 {
+		t.Error("metric middleware should have been removed")
+	}
+}
+
+// freeAddr returns a localhost address that is not listened
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	s := NewServer()
+	s.AddHandler("GET", "/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		s.WriteJson(w, r, map[string]string{"Data": "done"})
+	})
+	var closed []string
+	s.OnShutdown(func(context.Context) error {
+		closed = append(closed, "producer")
+		return nil
+	})
+	s.OnShutdown(func(context.Context) error {
+		closed = append(closed, "cron")
+		return nil
+	})
+	go s.ListenAndServe(addr)
+	time.Sleep(100 * time.Millisecond)
+
+	resChan := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if !strings.Contains(string(body), "done") {
+				err = fmt.Errorf("unexpected body: %s", body)
+			}
+		}
+		resChan <- err
+	}()
+	time.Sleep(100 * time.Millisecond) // request is in-flight
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+	if err := <-resChan; err != nil {
+		t.Errorf("in-flight request was dropped: %v", err)
+	}
+	if !reflect.DeepEqual(closed, []string{"cron", "producer"}) {
+		t.Errorf("unexpected shutdown order: %v", closed)
+	}
+	if err := s.Shutdown(ctx); err != nil || len(closed) != 2 {
+		t.Errorf("second Shutdown: %v, %v", err, closed)
+	}
+}
+
+func TestRunWaitsForShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	s := NewServer()
+	var isProducerClosed int32
+	s.OnShutdown(func(context.Context) error {
+		time.Sleep(300 * time.Millisecond) // ex: flushing kafka producer
+		atomic.StoreInt32(&isProducerClosed, 1)
+		return errors.New("flush timeout")
+	})
+	runErr := make(chan error, 1)
+	go func() { runErr <- s.Run(addr) }()
+	for i := 0; i < 50; i++ { // wait for the server is listening
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	go s.Shutdown(context.Background())
+	select {
+	case err := <-runErr:
+		if atomic.LoadInt32(&isProducerClosed) != 1 {
+			t.Error("Run returned before OnShutdown hooks finished")
+		}
+		if err == nil || err.Error() != "flush timeout" {
+			t.Errorf("Run must return the Shutdown error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+}
+
+func TestRecoverAndWriteError(t *testing.T) {
+	s := NewServer()
+	s.AddHandler("GET", "/panic", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["nil map"] = 1
+	})
+	s.AddHandler("GET", "/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		s.WriteError(w, r, &Error{Code: http.StatusNotFound,
+			Message: "user not found", Details: GetUrlParams(r)})
+	})
+	for i, c := range []struct {
+		path       string
+		statusCode int
+		message    string
+	}{
+		{"/panic", http.StatusInternalServerError, "Internal Server Error"},
+		{"/users/119", http.StatusNotFound, "user not found"},
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		var body ErrorResponse
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if err != nil {
+			t.Errorf("case %v: %v, body: %s", i, err, w.Body.Bytes())
+		}
+		if w.Code != c.statusCode || body.Error.Code != c.statusCode ||
+			body.Error.Message != c.message {
+			t.Errorf("case %v: unexpected response %v: %#v", i, w.Code, body)
+		}
+	}
+	isPanicCounted := false
+	for _, row := range s.Metric.GetCurrentMetric() {
+		if row.Key == "/panic_GET_panic" && row.Count == 1 {
+			isPanicCounted = true
+		}
+	}
+	if !isPanicCounted {
+		t.Errorf("panic was not counted: %v", s.Metric.GetCurrentMetric())
+	}
+}
+
+func TestRecoverWithoutLog(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	originalLogger := log.GlobalLogger
+	log.GlobalLogger = zap.New(core).Sugar()
+	defer func() { log.GlobalLogger = originalLogger }()
+
+	s := NewServerWithConf(nil, false, false, nil)
+	s.AddHandler("GET", "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("test")
+	})
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status %v", w.Code)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("a server disabled log must not log: %v", logs.AllUntimed())
+	}
+}
+
+func TestBind(t *testing.T) {
+	type Address struct {
+		City string `json:"city" validate:"required"`
+	}
+	type UpdateUserRequest struct {
+		Id      int64    `path:"id" validate:"required"`
+		Limit   int      `query:"limit" validate:"omitempty,min=1,max=100"`
+		Tags    []string `query:"tag" validate:"max=2"`
+		Token   string   `header:"X-Token"`
+		Email   string   `json:"email" validate:"required,email"`
+		Role    string   `json:"role" validate:"oneof=admin user"`
+		Address *Address `json:"address"`
+	}
+	s := NewServer()
+	s.AddHandler("PUT", "/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateUserRequest
+		if err := s.Bind(r, &req); err != nil {
+			s.WriteError(w, r, err)
+			return
+		}
+		s.WriteJson(w, r, req)
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("PUT", "/users/119?limit=20&tag=a&tag=b",
+		strings.NewReader(`{"email":"a@b.com","role":"admin","address":{"city":"HN"}}`))
+	r.Header.Set("X-Token", "secret")
+	s.router.ServeHTTP(w, r)
+	var ok UpdateUserRequest
+	json.Unmarshal(w.Body.Bytes(), &ok)
+	if w.Code != http.StatusOK || ok.Id != 119 || ok.Limit != 20 ||
+		!reflect.DeepEqual(ok.Tags, []string{"a", "b"}) || ok.Token != "secret" ||
+		ok.Email != "a@b.com" || ok.Address == nil || ok.Address.City != "HN" {
+		t.Errorf("unexpected response %v: %s", w.Code, w.Body.Bytes())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "/users/abc?limit=101&tag=a&tag=b&tag=c",
+		strings.NewReader(`{"email":"a.com","address":{}}`))
+	s.router.ServeHTTP(w, r)
+	var body struct {
+		Error struct{ Details []FieldError }
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	var failed []string
+	for _, e := range body.Error.Details {
+		failed = append(failed, e.Field+":"+e.Rule)
+	}
+	expect := []string{"id:type", "id:required", "limit:max", "tag:max",
+		"email:email", "role:oneof", "address.city:required"}
+	if w.Code != http.StatusBadRequest || !reflect.DeepEqual(failed, expect) {
+		t.Errorf("unexpected response %v: %v", w.Code, failed)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"email":`))
+	s.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid JSON: unexpected status %v", w.Code)
+	}
+}
+
+func TestBindInvalidTag(t *testing.T) {
+	for i, obj := range []interface{}{
+		&struct {
+			Name string `query:"name" validate:"requried"`
+		}{},
+		&struct {
+			Limit int `query:"limit" validate:"max=ten"`
+		}{},
+		&struct {
+			IsAdmin bool `query:"admin" validate:"min=1"`
+		}{},
+	} {
+		err := Bind(httptest.NewRequest("GET", "/?limit=1&admin=true", nil), obj)
+		if _, isHttpErr := err.(*Error); err == nil || isHttpErr {
+			t.Errorf("case %v: expected a non *Error error, got %#v", i, err)
+		}
+		if toError(err).Code != http.StatusInternalServerError {
+			t.Errorf("case %v: unexpected status %v", i, toError(err).Code)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Validate must panic for an invalid tag")
+		}
+	}()
+	Validate(struct {
+		Name string `validate:"requried"`
+	}{})
+}
+
+func TestPrometheus(t *testing.T) {
+	s := NewServer()
+	s.AddHandler("GET", "/match/:id", ExampleHandler())
+	s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/match/1", nil))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/__metrics/prometheus", nil))
+	line := `http_request_duration_seconds_count{method="GET",path="/match/:id",status="2xx"} 1`
+	if !strings.Contains(w.Body.String(), line) {
+		t.Errorf("expected line %v in output:\n%v", line, w.Body.String())
+	}
+
+	for key, expect := range map[string]map[string]string{
+		"/a_b/c_POST":      {"path": "/a_b/c", "method": "POST"},
+		"/panic_GET_panic": {"path": "/panic", "method": "GET", "status": "panic"},
+		"not_a_route":      {"key": "not_a_route"},
+	} {
+		if labels := metricKeyToLabels(key); !reflect.DeepEqual(labels, expect) {
+			t.Errorf("metricKeyToLabels(%v): %v, expect %v", key, labels, expect)
+		}
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	var captured *ResponseWriter
+	s := NewServer()
+	s.Use(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			captured = NewResponseWriter(w)
+			next(captured, r)
+		}
+	})
+	s.AddHandler("GET", "/created", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+		w.Write([]byte(" world"))
+	})
+	s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/created", nil))
+	if captured.Status() != http.StatusCreated || captured.Size() != 11 ||
+		captured.TimeToFirstByte() < 10*time.Millisecond {
+		t.Errorf("unexpected captured: %v, %v, %v",
+			captured.Status(), captured.Size(), captured.TimeToFirstByte())
+	}
+	if _, ok := http.ResponseWriter(captured).(http.Flusher); !ok {
+		t.Error("ResponseWriter must implement http.Flusher")
+	}
+	if StatusClass(captured.Status()) != "2xx" || StatusClass(503) != "5xx" {
+		t.Error("unexpected StatusClass")
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	originalLogger := log.GlobalLogger
+	log.GlobalLogger = zap.New(core).Sugar()
+	defer func() { log.GlobalLogger = originalLogger }()
+
+	s := NewServerWithConf(nil, false, false, nil)
+	var gotId string
+	s.AddHandler("GET", "/", func(w http.ResponseWriter, r *http.Request) {
+		gotId = GetRequestId(r)
+		ctx, _ := log.WithContext(r.Context(), "userId", 7)
+		log.FromContext(ctx).Infof("in handler")
+	})
+	for i, c := range []struct {
+		header     string
+		incomingId string
+		isEchoed   bool
+	}{
+		{"", "abc-123", true},
+		{"", "", false},
+		{"", "bad\nid", false},
+		{"", strings.Repeat("a", 200), false},
+		{"X-Trace-Id", "trace.1:2", true},
+	} {
+		if c.header != "" {
+			s.RequestIdHeader = c.header
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(s.RequestIdHeader, c.incomingId)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		if c.isEchoed && gotId != c.incomingId || !c.isEchoed && len(gotId) != 32 {
+			t.Errorf("case %v: unexpected request id %q", i, gotId)
+		}
+		if w.Header().Get(s.RequestIdHeader) != gotId {
+			t.Errorf("case %v: response header: expected %q, got %q",
+				i, gotId, w.Header().Get(s.RequestIdHeader))
+		}
+		entries := logs.TakeAll()
+		if len(entries) != 1 || entries[0].ContextMap()["requestId"] != gotId ||
+			entries[0].ContextMap()["userId"] != int64(7) {
+			t.Errorf("case %v: unexpected logs %v", i, entries)
+		}
+	}
+
+	if id := GetRequestId(httptest.NewRequest("GET", "/", nil)); id != "" {
+		t.Errorf("expected empty request id, got %q", id)
+	}
+}
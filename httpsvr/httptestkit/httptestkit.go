@@ -0,0 +1,328 @@
+// Package httptestkit helps testing handlers of a httpsvr_Server without
+// listening on a port: requests go through the real router and middlewares
+// and are recorded in-process.
+// Example:
+//
+//	kit := httptestkit.New(t, s)
+//	kit.GET("/users/1").Bearer(token).Do().
+//		Status(200).JSONField("Name", "Tung").Golden("get_user")
+package httptestkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// GoldenDir is the directory contains golden files, relative to the
+// directory of the test package
+var GoldenDir = "testdata"
+
+// Kit sends requests to a handler, usually a *httpsvr_Server
+type Kit struct {
+	t       testing.TB
+	handler http.Handler
+	header  http.Header
+}
+
+// New returns a Kit, failed assertions are reported to t
+func New(t testing.TB, handler http.Handler) *Kit {
+	return &Kit{t: t, handler: handler, header: make(http.Header)}
+}
+
+// SetHeader sets a header that will be sent in all requests of this kit
+func (k *Kit) SetHeader(key string, value string) *Kit {
+	k.header.Set(key, value)
+	return k
+}
+
+// Request is a request builder, call Do to send the request
+type Request struct {
+	kit    *Kit
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+	ctx    context.Context
+}
+
+// NewRequest returns a request builder, path can contain a query string
+func (k *Kit) NewRequest(method string, path string) *Request {
+	header := make(http.Header)
+	for key, values := range k.header {
+		header[key] = append([]string{}, values...)
+	}
+	return &Request{kit: k, method: method, path: path, query: make(url.Values),
+		header: header, ctx: context.Background()}
+}
+
+func (k *Kit) GET(path string) *Request    { return k.NewRequest("GET", path) }
+func (k *Kit) POST(path string) *Request   { return k.NewRequest("POST", path) }
+func (k *Kit) PUT(path string) *Request    { return k.NewRequest("PUT", path) }
+func (k *Kit) PATCH(path string) *Request  { return k.NewRequest("PATCH", path) }
+func (k *Kit) DELETE(path string) *Request { return k.NewRequest("DELETE", path) }
+
+// Header sets a request header
+func (r *Request) Header(key string, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+// Query adds a query param
+func (r *Request) Query(key string, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+// Bearer sets header "Authorization: Bearer {token}"
+func (r *Request) Bearer(token string) *Request {
+	return r.Header("Authorization", "Bearer "+token)
+}
+
+// Context sets the request context
+func (r *Request) Context(ctx context.Context) *Request {
+	r.ctx = ctx
+	return r
+}
+
+// Body sets a raw request body
+func (r *Request) Body(body string) *Request {
+	r.body = []byte(body)
+	return r
+}
+
+// JSON sets the request body is JSON of obj
+func (r *Request) JSON(obj interface{}) *Request {
+	r.kit.t.Helper()
+	body, err := json.Marshal(obj)
+	if err != nil {
+		r.kit.t.Fatalf("error marshal request body: %v", err)
+	}
+	r.body = body
+	return r.Header("Content-Type", "application/json")
+}
+
+// Do sends the request through the handler and records the response
+func (r *Request) Do() *Response {
+	r.kit.t.Helper()
+	target := r.path
+	if len(r.query) > 0 {
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + r.query.Encode()
+	}
+	req := httptest.NewRequest(r.method, target, bytes.NewReader(r.body))
+	req.Header = r.header
+	req = req.WithContext(r.ctx)
+	recorder := httptest.NewRecorder()
+	r.kit.handler.ServeHTTP(recorder, req)
+	return &Response{t: r.kit.t, Recorder: recorder, desc: r.method + " " + target}
+}
+
+// Response has assertion methods, a failed assertion calls t_Errorf
+type Response struct {
+	t        testing.TB
+	Recorder *httptest.ResponseRecorder
+	// desc is the request method and URL, used in error messages
+	desc string
+}
+
+// Code returns the response status code
+func (r *Response) Code() int { return r.Recorder.Code }
+
+// Body returns the response body
+func (r *Response) Body() []byte { return r.Recorder.Body.Bytes() }
+
+// Status asserts the response status code
+func (r *Response) Status(code int) *Response {
+	r.t.Helper()
+	if r.Recorder.Code != code {
+		r.t.Errorf("%v: expected status %v, got %v: %s",
+			r.desc, code, r.Recorder.Code, r.Body())
+	}
+	return r
+}
+
+// Header asserts a response header
+func (r *Response) Header(key string, value string) *Response {
+	r.t.Helper()
+	if got := r.Recorder.Header().Get(key); got != value {
+		r.t.Errorf("%v: expected header %v: %q, got %q", r.desc, key, value, got)
+	}
+	return r
+}
+
+// BodyContains asserts the response body contains substr
+func (r *Response) BodyContains(substr string) *Response {
+	r.t.Helper()
+	if !bytes.Contains(r.Body(), []byte(substr)) {
+		r.t.Errorf("%v: body does not contain %q: %s", r.desc, substr, r.Body())
+	}
+	return r
+}
+
+// JSON decodes the response body to outPtr
+func (r *Response) JSON(outPtr interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body(), outPtr); err != nil {
+		r.t.Errorf("%v: error unmarshal body: %v: %s", r.desc, err, r.Body())
+	}
+	return r
+}
+
+// JSONEq asserts the response body is equal to expected after normalizing
+// both, expected can be a JSON string or a value that will be marshalled
+func (r *Response) JSONEq(expected interface{}) *Response {
+	r.t.Helper()
+	expectedObj, err := normalizeJSON(expected)
+	if err != nil {
+		r.t.Fatalf("invalid expected JSON: %v", err)
+	}
+	gotObj, err := normalizeJSON(json.RawMessage(r.Body()))
+	if err != nil {
+		r.t.Errorf("%v: body is not JSON: %v: %s", r.desc, err, r.Body())
+		return r
+	}
+	if !reflect.DeepEqual(expectedObj, gotObj) {
+		expectedB, _ := json.Marshal(expectedObj)
+		r.t.Errorf("%v: expected body %s, got %s", r.desc, expectedB, r.Body())
+	}
+	return r
+}
+
+// JSONField asserts a field of the JSON body, path is keys and array
+// indexes joined by ".", ex: "Error.Code", "Users.0.Name"
+func (r *Response) JSONField(path string, expected interface{}) *Response {
+	r.t.Helper()
+	var body interface{}
+	if err := json.Unmarshal(r.Body(), &body); err != nil {
+		r.t.Errorf("%v: body is not JSON: %v: %s", r.desc, err, r.Body())
+		return r
+	}
+	got, err := lookup(body, path)
+	if err != nil {
+		r.t.Errorf("%v: %v: %s", r.desc, err, r.Body())
+		return r
+	}
+	expectedB, err := json.Marshal(expected)
+	if err != nil {
+		r.t.Fatalf("invalid expected value: %v", err)
+	}
+	expectedObj, _ := normalizeJSON(expectedB)
+	if !reflect.DeepEqual(expectedObj, got) {
+		r.t.Errorf("%v: field %v: expected %v, got %v", r.desc, path, expectedObj, got)
+	}
+	return r
+}
+
+// Golden compares the response (status, Content-Type and body, JSON body
+// is indented) with file GoldenDir/{name}.golden,
+// run tests with env UPDATE_GOLDEN=1 to write the golden files
+func (r *Response) Golden(name string) *Response {
+	r.t.Helper()
+	got := r.goldenContent()
+	path := filepath.Join(GoldenDir, name+".golden")
+	if isUpdate, _ := strconv.ParseBool(os.Getenv("UPDATE_GOLDEN")); isUpdate {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, got, 0644)
+		}
+		if err != nil {
+			r.t.Fatalf("error write golden file: %v", err)
+		}
+		return r
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		r.t.Errorf("%v: error read golden file (run with UPDATE_GOLDEN=1 to create): %v",
+			r.desc, err)
+		return r
+	}
+	if !bytes.Equal(expected, got) {
+		r.t.Errorf("%v: response differs from %v:\nexpected:\n%s\ngot:\n%s",
+			r.desc, path, expected, got)
+	}
+	return r
+}
+
+func (r *Response) goldenContent() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v %v\n", r.Recorder.Code, http.StatusText(r.Recorder.Code))
+	fmt.Fprintf(&buf, "Content-Type: %v\n\n", r.Recorder.Header().Get("Content-Type"))
+	body := r.Body()
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	buf.Write(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// normalizeJSON marshals then unmarshals v to interface{}, a string or
+// []byte v is parsed as JSON (so maps and structs can be compared)
+func normalizeJSON(v interface{}) (interface{}, error) {
+	var data []byte
+	switch value := v.(type) {
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	case json.RawMessage:
+		data = value
+	default:
+		var err error
+		data, err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var ret interface{}
+	err := json.Unmarshal(data, &ret)
+	return ret, err
+}
+
+var indexRegexp = regexp.MustCompile(`^[0-9]+$`)
+
+// lookup returns the value at path in a decoded JSON
+func lookup(obj interface{}, path string) (interface{}, error) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := obj.(type) {
+		case map[string]interface{}:
+			value, found := v[key]
+			if !found {
+				return nil, fmt.Errorf("field %v not found", path)
+			}
+			obj = value
+		case []interface{}:
+			if !indexRegexp.MatchString(key) {
+				return nil, fmt.Errorf("field %v: %v is not an index", path, key)
+			}
+			i, _ := strconv.Atoi(key)
+			if i >= len(v) {
+				return nil, fmt.Errorf("field %v: index %v out of range", path, i)
+			}
+			obj = v[i]
+		default:
+			return nil, fmt.Errorf("field %v not found", path)
+		}
+	}
+	return obj, nil
+}
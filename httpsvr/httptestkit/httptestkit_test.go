@@ -0,0 +1,91 @@
+package httptestkit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/daominah/gomicrokit/httpsvr"
+)
+
+// fakeT records failed assertions instead of failing the test
+type fakeT struct {
+	testing.TB
+	errs []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errs = append(t.errs, fmt.Sprintf(format, args...))
+}
+
+func newTestServer() *httpsvr.Server {
+	s := httpsvr.NewServer()
+	s.Group("/api", func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				httpsvr.WriteError(w, r, httpsvr.NewError(401, "need token"))
+				return
+			}
+			next(w, r)
+		}
+	}).AddHandler("GET", "/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		s.WriteJson(w, r, map[string]interface{}{
+			"Id":    httpsvr.GetUrlParams(r)["id"],
+			"Name":  "Tung",
+			"Roles": []string{"admin", "user"},
+			"Limit": r.URL.Query().Get("limit"),
+		})
+	})
+	s.AddHandler("POST", "/echo", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := s.ReadJson(r, &body); err != nil {
+			httpsvr.WriteError(w, r, httpsvr.NewError(400, err.Error()))
+			return
+		}
+		s.WriteJson(w, r, body)
+	})
+	return s
+}
+
+func TestKit(t *testing.T) {
+	kit := New(t, newTestServer())
+	kit.GET("/api/users/7").Bearer("secret").Query("limit", "10").Do().
+		Status(200).
+		Header("Content-Type", "application/json").
+		JSONField("Id", "7").
+		JSONField("Roles.1", "user").
+		JSONField("Limit", "10").
+		JSONEq(`{"Id":"7","Limit":"10","Name":"Tung","Roles":["admin","user"]}`).
+		Golden("get_user")
+	kit.GET("/api/users/7").Do().Status(401).JSONField("Error.Code", 401).
+		Golden("get_user_unauthorized")
+
+	var echoed struct{ A int }
+	kit.POST("/echo").JSON(map[string]int{"A": 1}).Do().Status(200).JSON(&echoed)
+	if echoed.A != 1 {
+		t.Errorf("unexpected echoed body %+v", echoed)
+	}
+	kit.POST("/echo").Body("not json").Do().Status(400).BodyContains("invalid")
+
+	kit.SetHeader("Authorization", "Bearer secret")
+	kit.GET("/api/users/8").Do().Status(200).JSONField("Id", "8")
+}
+
+func TestKitFailures(t *testing.T) {
+	ft := &fakeT{TB: t}
+	kit := New(ft, newTestServer())
+	kit.GET("/api/users/7").Bearer("secret").Do().
+		Status(201).
+		Header("Content-Type", "text/plain").
+		JSONField("Id", 7).
+		JSONField("Roles.5", "x").
+		JSONField("Missing", 1).
+		JSONEq(map[string]string{"Id": "7"}).
+		BodyContains("nobody").
+		Golden("not_exist")
+	if len(ft.errs) != 8 {
+		t.Errorf("expected 8 failed assertions, got %v: %v", len(ft.errs), ft.errs)
+	}
+}
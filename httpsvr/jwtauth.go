@@ -0,0 +1,202 @@
+package httpsvr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/daominah/gomicrokit/auth/jwt"
+)
+
+// AuthInfo is the decoded auth info of a JWT (the input of
+// JWTer_CreateAuthToken), ex: {"UserId": 1, "Roles": ["admin"]}
+type AuthInfo map[string]interface{}
+
+// JWTConfig configs JWTMiddleware
+type JWTConfig struct {
+	JWTer *jwt.JWTer
+	// token is read from header "Authorization: Bearer {token}", if it is
+	// empty, then from cookie CookieName, then from query param QueryParam
+	// (ex: "access_token" for websocket clients that cannot set headers),
+	// empty CookieName or QueryParam means do not read the source
+	CookieName string
+	QueryParam string
+	// IsOptional allows requests without token (GetAuthInfo returns false),
+	// requests that have an invalid token are still rejected
+	IsOptional bool
+	// RolesField is the auth info field checked by RequireRoles, default "Roles"
+	RolesField string
+	// ScopesField is the auth info field checked by RequireScopes,
+	// default "Scopes", the field can be a list or a space separated string
+	ScopesField string
+}
+
+// JWTMiddleware verifies the token of the request by conf_JWTer,
+// responds 401 if the token is absent or invalid. The auth info can be read
+// by GetAuthInfo or ReadAuthInfo.
+// Example: s.Group("/api", JWTMiddleware(conf)).AddHandler("DELETE",
+// "/users/:id", h, RequireRoles("admin"))
+func JWTMiddleware(conf JWTConfig) Middleware {
+	if conf.RolesField == "" {
+		conf.RolesField = "Roles"
+	}
+	if conf.ScopesField == "" {
+		conf.ScopesField = "Scopes"
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token := extractToken(r, conf)
+			if token == "" {
+				if conf.IsOptional {
+					next(w, r)
+					return
+				}
+				writeUnauthorized(w, r, "missing token")
+				return
+			}
+			var info AuthInfo
+			err := conf.JWTer.CheckAuthToken(token, &info)
+			if err != nil {
+				writeUnauthorized(w, r, fmt.Sprintf("invalid token: %v", err))
+				return
+			}
+			auth := &authContext{info: info,
+				roles:  toStrings(info[conf.RolesField]),
+				scopes: toStrings(info[conf.ScopesField])}
+			ctx := context.WithValue(r.Context(), CtxAuthInfo, auth)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// extractToken returns "" if the request does not have a token
+func extractToken(r *http.Request, conf JWTConfig) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	if conf.CookieName != "" {
+		if cookie, err := r.Cookie(conf.CookieName); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+	if conf.QueryParam != "" {
+		return r.URL.Query().Get(conf.QueryParam)
+	}
+	return ""
+}
+
+// CtxAuthInfo is the context key of the auth info of the request
+const CtxAuthInfo ctxKeyType = "CtxAuthInfo"
+
+// authContext is saved in the request context by JWTMiddleware
+type authContext struct {
+	info   AuthInfo
+	roles  []string
+	scopes []string
+}
+
+func getAuthContext(r *http.Request) *authContext {
+	auth, _ := r.Context().Value(CtxAuthInfo).(*authContext)
+	return auth
+}
+
+// GetAuthInfo returns the auth info of the request that was verified by
+// JWTMiddleware, returns false if the request is not authenticated
+func GetAuthInfo(r *http.Request) (AuthInfo, bool) {
+	auth := getAuthContext(r)
+	if auth == nil {
+		return nil, false
+	}
+	return auth.info, true
+}
+
+// ReadAuthInfo reads the auth info of the request to outPtr,
+// ex: outPtr is a *struct{UserId int64; Roles []string}.
+// If the request is not authenticated, the returned error is a 401 *Error.
+func ReadAuthInfo(r *http.Request, outPtr interface{}) error {
+	info, ok := GetAuthInfo(r)
+	if !ok {
+		return errNotAuthenticated
+	}
+	bodyB, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bodyB, outPtr)
+}
+
+var errNotAuthenticated = NewError(http.StatusUnauthorized, "request is not authenticated")
+
+// RequireRoles is a route middleware that responds 403 if the auth info does
+// not have any of the roles (401 if the request is not authenticated),
+// it must be called after JWTMiddleware
+func RequireRoles(roles ...string) Middleware {
+	return requireAuth("role", roles, false, func(a *authContext) []string { return a.roles })
+}
+
+// RequireScopes is a route middleware that responds 403 if the auth info
+// does not have all the scopes (401 if the request is not authenticated),
+// it must be called after JWTMiddleware
+func RequireScopes(scopes ...string) Middleware {
+	return requireAuth("scope", scopes, true, func(a *authContext) []string { return a.scopes })
+}
+
+func requireAuth(what string, required []string, isRequireAll bool,
+	getGranted func(a *authContext) []string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			auth := getAuthContext(r)
+			if auth == nil {
+				writeUnauthorized(w, r, "missing token")
+				return
+			}
+			granted := make(map[string]bool)
+			for _, v := range getGranted(auth) {
+				granted[v] = true
+			}
+			nMatched := 0
+			for _, v := range required {
+				if granted[v] {
+					nMatched++
+				}
+			}
+			isAllowed := nMatched > 0 || len(required) == 0
+			if isRequireAll {
+				isAllowed = nMatched == len(required)
+			}
+			if !isAllowed {
+				quantifier := "one of"
+				if isRequireAll {
+					quantifier = "all of"
+				}
+				WriteError(w, r, &Error{Code: http.StatusForbidden,
+					Message: fmt.Sprintf("require %v %vs %v", quantifier, what, required)})
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="restricted"`)
+	WriteError(w, r, NewError(http.StatusUnauthorized, message))
+}
+
+// toStrings converts a list or a space separated string to a []string
+func toStrings(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		ret := make([]string, 0, len(value))
+		for _, e := range value {
+			ret = append(ret, fmt.Sprintf("%v", e))
+		}
+		return ret
+	}
+	return nil
+}
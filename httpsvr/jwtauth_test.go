@@ -0,0 +1,103 @@
+package httpsvr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daominah/gomicrokit/auth/genrsa"
+	"github.com/daominah/gomicrokit/auth/jwt"
+)
+
+func TestJWTMiddleware(t *testing.T) {
+	idRsa, idRsaPub := genrsa.GenRsaPair()
+	jwter, err := jwt.NewJWTer(idRsa, idRsaPub, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type UserInfo struct {
+		UserId int64
+		Roles  []string
+		Scopes string
+	}
+	adminToken := jwter.CreateAuthToken(UserInfo{UserId: 1, Roles: []string{"admin"},
+		Scopes: "users:read users:write"})
+	userToken := jwter.CreateAuthToken(UserInfo{UserId: 2, Roles: []string{"user"},
+		Scopes: "users:read"})
+
+	s := NewServer()
+	api := s.Group("/api", JWTMiddleware(JWTConfig{JWTer: jwter,
+		CookieName: "token", QueryParam: "access_token"}))
+	me := func(w http.ResponseWriter, r *http.Request) {
+		var info UserInfo
+		if err := ReadAuthInfo(r, &info); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		s.WriteJson(w, r, info)
+	}
+	api.AddHandler("GET", "/me", me)
+	api.AddHandler("DELETE", "/users/:id", me, RequireRoles("admin", "root"))
+	api.AddHandler("PUT", "/users/:id", me, RequireScopes("users:read", "users:write"))
+	public := func(w http.ResponseWriter, r *http.Request) {
+		info, _ := GetAuthInfo(r) // nil if the request does not have token
+		s.WriteJson(w, r, info)
+	}
+	s.AddHandler("GET", "/public", public,
+		JWTMiddleware(JWTConfig{JWTer: jwter, IsOptional: true}))
+
+	for i, c := range []struct {
+		method     string
+		path       string
+		header     string
+		cookie     string
+		statusCode int
+		userId     int64
+	}{
+		{"GET", "/api/me", "Bearer " + userToken, "", 200, 2},
+		{"GET", "/api/me", "bearer " + userToken, "", 200, 2},
+		{"GET", "/api/me", "", userToken, 200, 2},
+		{"GET", "/api/me?access_token=" + adminToken, "", "", 200, 1},
+		{"GET", "/api/me", "", "", 401, 0},
+		{"GET", "/api/me", "Bearer invalid", "", 401, 0},
+		{"DELETE", "/api/users/3", "Bearer " + adminToken, "", 200, 1},
+		{"DELETE", "/api/users/3", "Bearer " + userToken, "", 403, 0},
+		{"PUT", "/api/users/3", "Bearer " + adminToken, "", 200, 1},
+		{"PUT", "/api/users/3", "Bearer " + userToken, "", 403, 0},
+		{"GET", "/public", "Bearer " + userToken, "", 200, 2},
+		{"GET", "/public", "", "", 200, 0},
+		{"GET", "/public", "Bearer invalid", "", 401, 0},
+	} {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		if c.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "token", Value: c.cookie})
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		if w.Code != c.statusCode {
+			t.Errorf("case %v: expected status %v, got %v: %v",
+				i, c.statusCode, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code == 401 && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("case %v: missing WWW-Authenticate", i)
+		}
+		if w.Code != 200 {
+			var errResp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil ||
+				errResp.Error.Code != c.statusCode {
+				t.Errorf("case %v: unexpected error body %v", i, w.Body.String())
+			}
+			continue
+		}
+		var info UserInfo
+		json.Unmarshal(w.Body.Bytes(), &info)
+		if info.UserId != c.userId {
+			t.Errorf("case %v: expected user %v, got %v", i, c.userId, info.UserId)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package httpsvr
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+// DefaultShutdownTimeout is used by Run if Server_ShutdownTimeout is not set
+const DefaultShutdownTimeout = 30 * time.Second
+
+// lifecycle holds hooks and ReadyCheckers of a Server,
+// it is a pointer field so methods with a Server value receiver are safe
+type lifecycle struct {
+	onStarts    []func() error
+	onShutdowns []func(ctx context.Context) error
+	isShutdown  bool
+	// shutdownDone is closed when Shutdown returned, shutdownErr is
+	// its result
+	shutdownDone chan struct{}
+	shutdownErr  error
+	// servers are created by Serve for listeners, they are shut down
+	// together with Server_config
+	servers []*http.Server
+	// readyCheckerNames keeps the order checkers were added
+	readyCheckerNames []string
+	readyCheckers     map[string]ReadyChecker
+	mutex             *sync.Mutex
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{mutex: &sync.Mutex{}, shutdownDone: make(chan struct{})}
+}
+
+// OnStart adds a hook that will be called by Run before the server listens,
+// hooks are called in the order they were added, if a hook returns an error,
+// Run will stop and return that error.
+func (s *Server) OnStart(hook func() error) {
+	s.lifecycle.mutex.Lock()
+	s.lifecycle.onStarts = append(s.lifecycle.onStarts, hook)
+	s.lifecycle.mutex.Unlock()
+}
+
+// OnShutdown adds a hook that will be called by Shutdown after all
+// connections were drained, ex: close a kafka producer, stop a cron.
+// Hooks are called in the reverse order they were added (like defer),
+// so a resource started later will be closed first.
+func (s *Server) OnShutdown(hook func(ctx context.Context) error) {
+	s.lifecycle.mutex.Lock()
+	s.lifecycle.onShutdowns = append(s.lifecycle.onShutdowns, hook)
+	s.lifecycle.mutex.Unlock()
+}
+
+// OnShutdownStart adds a hook that will be called (in a new goroutine) when
+// Shutdown begins, before waiting for in-flight requests. It should make
+// long-lived handlers return, ex: s.OnShutdownStart(sseBroker.Close),
+// else Shutdown waits for them until its ctx is done.
+func (s *Server) OnShutdownStart(hook func()) {
+	s.config.RegisterOnShutdown(hook)
+}
+
+// Shutdown gracefully shuts down the server: stops listening, waits for
+// in-flight requests to finish (or ctx is done) then calls OnShutdown hooks.
+// Calling Shutdown more than once only shuts down the server once, later
+// calls wait for the first call (or their ctx is done) and return its result.
+// Returns the first error from draining connections or the hooks.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lifecycle.mutex.Lock()
+	if s.lifecycle.isShutdown {
+		s.lifecycle.mutex.Unlock()
+		select {
+		case <-s.lifecycle.shutdownDone:
+			return s.lifecycle.shutdownErr
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.lifecycle.isShutdown = true
+	hooks := append([]func(context.Context) error{}, s.lifecycle.onShutdowns...)
+	servers := append([]*http.Server{s.config}, s.lifecycle.servers...)
+	s.lifecycle.mutex.Unlock()
+
+	log.Infof("shutting down http server")
+	errs := make([]error, len(servers))
+	wg := &sync.WaitGroup{}
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}(i, srv)
+	}
+	wg.Wait()
+	var retErr error
+	for _, err := range errs {
+		if err != nil {
+			log.Infof("error when http server shutdown: %v", err)
+			if retErr == nil {
+				retErr = err
+			}
+		}
+	}
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i](ctx)
+		if err != nil {
+			log.Infof("error when call OnShutdown hook %v: %v", i, err)
+			if retErr == nil {
+				retErr = err
+			}
+		}
+	}
+	log.Infof("http server shut down")
+	s.lifecycle.shutdownErr = retErr
+	close(s.lifecycle.shutdownDone)
+	return retErr
+}
+
+// Run calls OnStart hooks, listens on addr, then waits for SIGINT or SIGTERM
+// to gracefully Shutdown the server (timeout is Server_ShutdownTimeout).
+// Returns nil if the server was shut down without error. If Shutdown is
+// called by another goroutine, Run returns after that Shutdown finished.
+func (s *Server) Run(addr string) error {
+	return s.run(func() error {
+		log.Infof("http server listening on %v", addr)
+		return s.ListenAndServe(addr)
+	})
+}
+
+// RunListeners is Run with multiple listeners (see Serve)
+func (s *Server) RunListeners(listeners ...ListenerConfig) error {
+	return s.run(func() error { return s.Serve(listeners...) })
+}
+
+func (s *Server) run(serve func() error) error {
+	s.lifecycle.mutex.Lock()
+	onStarts := append([]func() error{}, s.lifecycle.onStarts...)
+	s.lifecycle.mutex.Unlock()
+	for i, hook := range onStarts {
+		if err := hook(); err != nil {
+			log.Infof("error when call OnStart hook %v: %v", i, err)
+			return err
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	serveErrChan := make(chan error, 1)
+	go func() { serveErrChan <- serve() }()
+
+	var serveErr error
+	select {
+	case sig := <-sigChan:
+		log.Infof("received signal %v", sig)
+	case serveErr = <-serveErrChan:
+		if serveErr == http.ErrServerClosed {
+			// Shutdown was called by user, wait for it to drain connections
+			// and call OnShutdown hooks, so main does not exit too early
+			<-s.lifecycle.shutdownDone
+			return s.lifecycle.shutdownErr
+		}
+		log.Infof("error when http server listen: %v", serveErr)
+	}
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	if serveErr != nil {
+		return serveErr
+	}
+	return err
+}
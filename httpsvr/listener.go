@@ -0,0 +1,163 @@
+package httpsvr
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+// ListenerConfig describes a listener of Server_Serve and which routes are
+// visible on it, ex: public routes on ":80", admin endpoints on an internal
+// port or a Unix socket:
+//
+//	s.Serve(
+//		ListenerConfig{Addr: ":80", ExcludePaths: []string{"/__*", "/debug/*"}},
+//		ListenerConfig{Network: "unix", Addr: "/run/app.sock"},
+//		ListenerConfig{Addr: "127.0.0.1:9090", Paths: []string{"/__*", "/debug/*"}},
+//	)
+type ListenerConfig struct {
+	// Network is "tcp" or "unix", default "tcp"
+	Network string
+	// Addr is a TCP address (ex: ":8080") or a Unix socket path,
+	// a stale socket file is removed before listening
+	Addr string
+	// Listener is a pre-made listener, if it is not nil, Network and Addr
+	// are ignored
+	Listener net.Listener
+	// TLS is optional, if it is not nil, the listener serves HTTPS
+	TLS *TLSConfig
+	// Paths are URL paths visible on this listener, a path ends with "*" is
+	// a prefix, ex: "/__metric", "/debug/*". Empty means all paths.
+	Paths []string
+	// ExcludePaths are URL paths that are responded 404 on this listener
+	ExcludePaths []string
+}
+
+// String returns a description of the listener address for logging
+func (conf ListenerConfig) String() string {
+	if conf.Listener != nil {
+		return fmt.Sprintf("%v:%v", conf.Listener.Addr().Network(), conf.Listener.Addr())
+	}
+	network := conf.Network
+	if network == "" {
+		network = "tcp"
+	}
+	return fmt.Sprintf("%v:%v", network, conf.Addr)
+}
+
+// Serve serves on all listeners, it blocks until a listener stopped,
+// returns http_ErrServerClosed after Shutdown (Shutdown closes all
+// listeners), returns the first error if a listener cannot be created.
+// Requests on every listener go through the same router and middlewares.
+func (s *Server) Serve(listeners ...ListenerConfig) error {
+	if len(listeners) == 0 {
+		return fmt.Errorf("no listener")
+	}
+	netListeners := make([]net.Listener, 0, len(listeners))
+	closeAll := func() {
+		for _, l := range netListeners {
+			l.Close()
+		}
+	}
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, conf := range listeners {
+		l, err := listen(conf)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("error listen %v: %v", conf, err)
+		}
+		netListeners = append(netListeners, l)
+		srv := s.newListenerServer(conf)
+		if conf.TLS != nil {
+			srv.TLSConfig, err = newServerTLSConfig(*conf.TLS)
+			if err != nil {
+				closeAll()
+				return err
+			}
+			if conf.TLS.IsDisableHTTP2 {
+				srv.TLSNextProto = make(
+					map[string]func(*http.Server, *tls.Conn, http.Handler))
+			}
+		}
+		servers = append(servers, srv)
+	}
+
+	s.lifecycle.mutex.Lock()
+	if s.lifecycle.isShutdown {
+		s.lifecycle.mutex.Unlock()
+		closeAll()
+		return http.ErrServerClosed
+	}
+	s.lifecycle.servers = append(s.lifecycle.servers, servers...)
+	s.lifecycle.mutex.Unlock()
+
+	errChan := make(chan error, len(listeners))
+	for i := range listeners {
+		go func(conf ListenerConfig, srv *http.Server, l net.Listener) {
+			log.Infof("http server listening on %v", conf)
+			if conf.TLS != nil {
+				errChan <- srv.ServeTLS(l, "", "")
+			} else {
+				errChan <- srv.Serve(l)
+			}
+		}(listeners[i], servers[i], netListeners[i])
+	}
+	return <-errChan
+}
+
+// newListenerServer returns a http_Server that has the same configs as
+// s_config, its handler only serves the visible paths of the listener
+func (s *Server) newListenerServer(conf ListenerConfig) *http.Server {
+	var handler http.Handler = s.router
+	if len(conf.Paths) > 0 || len(conf.ExcludePaths) > 0 {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			isVisible := len(conf.Paths) == 0 ||
+				isMatchedPath(conf.Paths, r.URL.Path, r.URL.Path)
+			if !isVisible || isMatchedPath(conf.ExcludePaths, r.URL.Path, r.URL.Path) {
+				WriteError(w, r, NewError(http.StatusNotFound,
+					http.StatusText(http.StatusNotFound)))
+				return
+			}
+			s.router.ServeHTTP(w, r)
+		})
+	}
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       s.config.ReadTimeout,
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+		MaxHeaderBytes:    s.config.MaxHeaderBytes,
+		ErrorLog:          s.config.ErrorLog,
+	}
+}
+
+func listen(conf ListenerConfig) (net.Listener, error) {
+	if conf.Listener != nil {
+		return conf.Listener, nil
+	}
+	switch conf.Network {
+	case "", "tcp", "tcp4", "tcp6":
+		network := conf.Network
+		if network == "" {
+			network = "tcp"
+		}
+		return net.Listen(network, conf.Addr)
+	case "unix":
+		if info, err := os.Stat(conf.Addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			// a socket file is left if the previous process was killed,
+			// if another process is listening, the file is in use anyway
+			if conn, err := net.Dial("unix", conf.Addr); err != nil {
+				os.Remove(conf.Addr)
+			} else {
+				conn.Close()
+			}
+		}
+		return net.Listen("unix", conf.Addr)
+	}
+	return nil, fmt.Errorf("unsupported network %v", conf.Network)
+}
@@ -0,0 +1,107 @@
+package httpsvr
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpsvr_listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sockPath := filepath.Join(dir, "app.sock")
+	// a stale socket file must not prevent listening
+	stale, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	preMade, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicAddr := freeAddr(t)
+
+	s := NewServer()
+	s.AddHandler("GET", "/hello", ExampleHandler())
+	admin := []string{"/__*", "/debug/*"}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(
+			ListenerConfig{Addr: publicAddr, ExcludePaths: admin},
+			ListenerConfig{Network: "unix", Addr: sockPath},
+			ListenerConfig{Listener: preMade, Paths: admin},
+		)
+	}()
+
+	tcpClient := &http.Client{Transport: &http.Transport{}}
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sockPath)
+		}}}
+	get := func(client *http.Client, url string) int {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ { // wait for the server is listening
+			resp, err = client.Get(url)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("error get %v: %v", url, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i, c := range []struct {
+		client     *http.Client
+		url        string
+		statusCode int
+	}{
+		{tcpClient, "http://" + publicAddr + "/hello", 200},
+		{tcpClient, "http://" + publicAddr + "/__metric", 404},
+		{unixClient, "http://unix/hello", 200},
+		{unixClient, "http://unix/__metric", 200},
+		{tcpClient, "http://" + preMade.Addr().String() + "/hello", 404},
+		{tcpClient, "http://" + preMade.Addr().String() + "/__metric", 200},
+	} {
+		if got := get(c.client, c.url); got != c.statusCode {
+			t.Errorf("case %v: %v: expected %v, got %v", i, c.url, c.statusCode, got)
+		}
+	}
+
+	// connections in state new are only closed by Shutdown after 5 seconds
+	tcpClient.CloseIdleConnections()
+	unixClient.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-serveErr:
+		if err != http.ErrServerClosed {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+	if _, err := os.Stat(sockPath); !os.IsNotExist(err) {
+		t.Errorf("socket file must be removed after Shutdown: %v", err)
+	}
+	if err := s.Serve(ListenerConfig{Addr: "127.0.0.1:0"}); err != http.ErrServerClosed {
+		t.Errorf("Serve after Shutdown: expected ErrServerClosed, got %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+package httpsvr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daominah/gomicrokit/gofast"
+	"github.com/daominah/gomicrokit/log"
+	"github.com/daominah/gomicrokit/metric"
+)
+
+// Middleware wraps a handler to do something before and after it,
+// ex: authenticate, log, measure duration.
+// A middleware can stop the chain by not calling next.
+type Middleware func(next http.HandlerFunc) http.HandlerFunc
+
+// Route describes a handler registered by AddHandler,
+// middlewares can get the route of the request by calling GetRoute
+type Route struct {
+	Method string
+	// Path is the full pattern (included group prefix), ex: /match/:id
+	Path string
+}
+
+// MetricKey returns the key used in Server_Metric for this route
+func (r Route) MetricKey() string {
+	return fmt.Sprintf("%v_%v", r.Path, r.Method)
+}
+
+// routeRegistry holds registered methods of paths and per route settings
+// (CORS policies, OpenAPI docs)
+type routeRegistry struct {
+	// chainVersion is increased when middlewares of a group are changed,
+	// accessed atomically (first field for 64-bit alignment)
+	chainVersion uint64
+	routeMethods map[string][]string
+	// optionsHandlers are handlers of automatic OPTIONS routes, a user
+	// OPTIONS handler added after other methods of the path replaces one
+	optionsHandlers map[string]http.HandlerFunc
+	corsPolicies    map[string]*corsPolicy
+	docs            map[Route]RouteDoc
+	mutex           *sync.RWMutex
+}
+
+func newRouteRegistry() *routeRegistry {
+	return &routeRegistry{
+		routeMethods:    make(map[string][]string),
+		optionsHandlers: make(map[string]http.HandlerFunc),
+		corsPolicies:    make(map[string]*corsPolicy),
+		docs:            make(map[Route]RouteDoc),
+		mutex:           &sync.RWMutex{},
+	}
+}
+
+// addRoute returns true if the path should have an automatic OPTIONS handler
+// (the first method of the path is added and it is not OPTIONS)
+func (c *routeRegistry) addRoute(method string, path string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	methods, found := c.routeMethods[path]
+	c.routeMethods[path] = append(methods, method)
+	return !found && method != http.MethodOptions
+}
+
+// optionsHandler returns the current handler of the automatic OPTIONS route
+func (c *routeRegistry) optionsHandler(path string) http.HandlerFunc {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.optionsHandlers[path]
+}
+
+func (c *routeRegistry) setOptionsHandler(path string, handler http.HandlerFunc) {
+	c.mutex.Lock()
+	c.optionsHandlers[path] = handler
+	c.mutex.Unlock()
+}
+
+// replaceOptionsHandler returns false if the path does not have an
+// automatic OPTIONS route, it panics if the route was already replaced
+func (c *routeRegistry) replaceOptionsHandler(path string, handler http.HandlerFunc) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, found := c.optionsHandlers[path]; !found {
+		return false
+	}
+	for _, method := range c.routeMethods[path] {
+		if method == http.MethodOptions {
+			panic("a handle is already registered for path '" + path + "'")
+		}
+	}
+	c.optionsHandlers[path] = handler
+	c.routeMethods[path] = append(c.routeMethods[path], http.MethodOptions)
+	return true
+}
+
+func (c *routeRegistry) hasRoute(method string, path string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, m := range c.routeMethods[path] {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *routeRegistry) methods(path string) []string {
+	c.mutex.RLock()
+	ret := append([]string{}, c.routeMethods[path]...)
+	c.mutex.RUnlock()
+	sort.Strings(ret)
+	return ret
+}
+
+// middlewaresChanged makes all routes rebuild their middleware chains
+func (c *routeRegistry) middlewaresChanged() {
+	atomic.AddUint64(&c.chainVersion, 1)
+}
+
+// paths returns all registered paths in alphabetical order
+func (c *routeRegistry) paths() []string {
+	c.mutex.RLock()
+	ret := make([]string, 0, len(c.routeMethods))
+	for path := range c.routeMethods {
+		ret = append(ret, path)
+	}
+	c.mutex.RUnlock()
+	sort.Strings(ret)
+	return ret
+}
+
+// Group is a set of routes that have a same path prefix and middlewares,
+// Group must be inited by calling Server_Group or Group_Group
+type Group struct {
+	server *Server
+	parent *Group
+	prefix string
+	// middlewares of this group, not included parent's middlewares
+	middlewares []Middleware
+	// cors is the CORS policy of this group, nil means use parent's policy
+	cors  *corsPolicy
+	mutex *sync.RWMutex
+}
+
+func newGroup(s *Server, parent *Group, prefix string, mws []Middleware) *Group {
+	return &Group{
+		server:      s,
+		parent:      parent,
+		prefix:      prefix,
+		middlewares: append([]Middleware{}, mws...),
+		mutex:       &sync.RWMutex{},
+	}
+}
+
+// Group returns a sub group, path prefix of the sub group is
+// the concatenation of parent prefix and input prefix
+func (g *Group) Group(prefix string, mws ...Middleware) *Group {
+	return newGroup(g.server, g, prefix, mws)
+}
+
+// Use appends middlewares to this group, the middlewares affect all routes in
+// the group, included routes added before calling this func (their chains
+// are rebuilt, so existing middlewares are called with a new next)
+func (g *Group) Use(mws ...Middleware) {
+	g.mutex.Lock()
+	g.middlewares = append(g.middlewares, mws...)
+	g.mutex.Unlock()
+	g.server.routes.middlewaresChanged()
+}
+
+// fullPrefix returns prefixes of all ancestors and this group
+func (g *Group) fullPrefix() string {
+	if g.parent == nil {
+		return g.prefix
+	}
+	return g.parent.fullPrefix() + g.prefix
+}
+
+// chain returns middlewares of all ancestors then this group's middlewares,
+// the first middleware is the outermost
+func (g *Group) chain() []Middleware {
+	var ret []Middleware
+	if g.parent != nil {
+		ret = g.parent.chain()
+	}
+	g.mutex.RLock()
+	ret = append(ret, g.middlewares...)
+	g.mutex.RUnlock()
+	return ret
+}
+
+// AddHandler adds a handler to path "groupPrefix + path",
+// the handler is wrapped by (outermost first): server's middlewares,
+// group's middlewares, then input route middlewares.
+// An OPTIONS handler is added automatically for the path (see SetCORS),
+// a custom OPTIONS handler of the path replaces the automatic one.
+func (g *Group) AddHandler(method string, path string, handler http.HandlerFunc,
+	routeMws ...Middleware) {
+	defer func() { // in case of adding a same handler twice
+		if r := recover(); r != nil {
+			log.Errorf("error when AddHandler %v %v: %v", method, path, r)
+		}
+	}()
+	route := Route{Method: method, Path: g.fullPrefix() + path}
+	// be careful with augmenting handler, example stack overflow:
+	// 	f := func() { log.Println("f called") }
+	//	f = func() { f() }
+	//	f()
+	s := g.server
+	chain := newRouteChain(g, wrap(handler, routeMws))
+	routeFunc := func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), CtxRoute, route)
+		// CORS headers are written before middlewares, so browsers can
+		// read error responses of middlewares
+		if policy := s.corsPolicy(g, route.Path); policy != nil {
+			policy.writeActual(w, r)
+		}
+		chain.handler()(w, r.WithContext(ctx))
+	}
+	if method == http.MethodOptions &&
+		s.routes.replaceOptionsHandler(route.Path, routeFunc) {
+		return
+	}
+	g.server.router.HandlerFunc(method, route.Path, routeFunc)
+	if s.routes.addRoute(method, route.Path) {
+		preflight := Route{Method: http.MethodOptions, Path: route.Path}
+		s.routes.setOptionsHandler(route.Path,
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), CtxRoute, preflight)
+				s.handlePreflight(g, route.Path)(w, r.WithContext(ctx))
+			})
+		s.router.HandlerFunc(http.MethodOptions, route.Path,
+			func(w http.ResponseWriter, r *http.Request) {
+				s.routes.optionsHandler(route.Path)(w, r)
+			})
+	}
+}
+
+// routeChain is a route handler wrapped by its group middlewares, the chain
+// is built once and rebuilt only if Use or ResetMiddlewares was called
+// after AddHandler, so middlewares are not re-wrapped on every request
+type routeChain struct {
+	group        *Group
+	routeHandler http.HandlerFunc
+	built        atomic.Value // *builtChain
+	mutex        sync.Mutex
+}
+
+type builtChain struct {
+	version uint64
+	handler http.HandlerFunc
+}
+
+func newRouteChain(g *Group, routeHandler http.HandlerFunc) *routeChain {
+	c := &routeChain{group: g, routeHandler: routeHandler}
+	c.handler()
+	return c
+}
+
+// handler returns the cached chain, rebuilds it if middlewares were changed
+func (c *routeChain) handler() http.HandlerFunc {
+	version := atomic.LoadUint64(&c.group.server.routes.chainVersion)
+	if built, ok := c.built.Load().(*builtChain); ok && built.version == version {
+		return built.handler
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if built, ok := c.built.Load().(*builtChain); ok && built.version == version {
+		return built.handler
+	}
+	built := &builtChain{version: version,
+		handler: wrap(c.routeHandler, c.group.chain())}
+	c.built.Store(built)
+	return built.handler
+}
+
+// wrap returns a handler that calls mws[0], mws[1], .., handler
+func wrap(handler http.HandlerFunc, mws []Middleware) http.HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
+
+// DefaultRequestIdHeader is used if Server_RequestIdHeader is not set
+const DefaultRequestIdHeader = "X-Request-Id"
+
+// maxRequestIdLen limits the incoming request id, longer ids are replaced
+const maxRequestIdLen = 128
+
+// RequestIdMiddleware reads the request id from the request header (an UUID
+// is generated if the header is empty or invalid), saves it to the request
+// context, echoes it in the response header.
+// The request id can be read by GetRequestId, and log_FromContext(r_Context())
+// returns a logger that has field requestId.
+func RequestIdMiddleware(header string) Middleware {
+	if header == "" {
+		header = DefaultRequestIdHeader
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			serveWithRequestId(w, r, next, header)
+		}
+	}
+}
+
+// requestIdMiddleware is a RequestIdMiddleware that reads s_RequestIdHeader on
+// every request, so the header can be changed after NewServer
+func (s *Server) requestIdMiddleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			header := s.RequestIdHeader
+			if header == "" {
+				header = DefaultRequestIdHeader
+			}
+			serveWithRequestId(w, r, next, header)
+		}
+	}
+}
+
+func serveWithRequestId(w http.ResponseWriter, r *http.Request,
+	next http.HandlerFunc, header string) {
+	requestId := r.Header.Get(header)
+	if !isValidRequestId(requestId) {
+		requestId = gofast.GenUUID()
+	}
+	w.Header().Set(header, requestId)
+	next(w, r.WithContext(withRequestId(r.Context(), requestId)))
+}
+
+// withRequestId returns a copy of ctx that holds the request id and
+// a logger that has field requestId
+func withRequestId(ctx context.Context, requestId string) context.Context {
+	ctx = context.WithValue(ctx, CtxRequestId, requestId)
+	ctx, _ = log.WithContext(ctx, "requestId", requestId)
+	return ctx
+}
+
+// isValidRequestId only allows a short id of letters, digits and "-_.:",
+// so a client cannot inject anything to logs
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLen {
+		return false
+	}
+	for _, c := range requestId {
+		isValid := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':'
+		if !isValid {
+			return false
+		}
+	}
+	return true
+}
+
+// LogMiddleware logs the request when it comes and when it is responded
+// (included status code, body size, time to first byte and duration of the
+// response). If the request does not have a request id (RequestIdMiddleware
+// was removed by ResetMiddlewares), an unique id is generated.
+func LogMiddleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			requestId := GetRequestId(r)
+			if requestId == "" {
+				requestId = gofast.GenUUID()
+				r = r.WithContext(withRequestId(r.Context(), requestId))
+			}
+			query := r.URL.Query().Encode()
+			if query != "" {
+				query = "?" + query
+			}
+			log.Infof("http request %v from %v: %v %v%v",
+				requestId, r.RemoteAddr, r.Method, r.URL.Path, query)
+			rw := NewResponseWriter(w)
+			beginTime := time.Now()
+			next(rw, r)
+			log.Infof("http responded %v to %v: %v %v%v: status %v, "+
+				"size %v bytes, ttfb %v, duration %v",
+				requestId, r.RemoteAddr, r.Method, r.URL.Path, query,
+				rw.Status(), rw.Size(), rw.TimeToFirstByte(), time.Since(beginTime))
+		}
+	}
+}
+
+// MetricMiddleware observes count and duration of requests to the route,
+// broken down by response status class,
+// metric key is "path_method_statusClass", ex: "/match/:id_GET_2xx"
+func MetricMiddleware(m metric.Metric) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rw := NewResponseWriter(w)
+			beginTime := time.Now()
+			next(rw, r)
+			metricKey := fmt.Sprintf("%v_%v",
+				GetRoute(r).MetricKey(), StatusClass(rw.Status()))
+			m.Count(metricKey)
+			m.Duration(metricKey, time.Since(beginTime))
+		}
+	}
+}
+
+// CtxRoute is the context key of the matched Route
+const CtxRoute ctxKeyType = "CtxRoute"
+
+// GetRoute returns the matched Route of the request,
+// returns a Route from the request if it was not served by AddHandler
+func GetRoute(r *http.Request) Route {
+	route, ok := r.Context().Value(CtxRoute).(Route)
+	if !ok {
+		return Route{Method: r.Method, Path: r.URL.Path}
+	}
+	return route
+}
@@ -0,0 +1,418 @@
+package httpsvr
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+// RouteDoc describes a route in the OpenAPI document
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Request is a value of the request type (usually the type that the
+	// handler Binds to), ex: UpdateUserRequest{}.
+	// Fields that have tag path, query or header are parameters,
+	// other fields are the JSON body. The validate tag is also documented.
+	Request interface{}
+	// Response is a value of the 200 response body type
+	Response interface{}
+}
+
+// OpenAPIInfo is the info object of the OpenAPI document
+type OpenAPIInfo struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+// AddHandlerWithDoc is AddHandler that also sets the doc of the route for
+// the OpenAPI document
+func (s *Server) AddHandlerWithDoc(method string, path string, doc RouteDoc,
+	handler http.HandlerFunc, routeMws ...Middleware) {
+	s.root.AddHandlerWithDoc(method, path, doc, handler, routeMws...)
+}
+
+// AddHandlerWithDoc is AddHandler that also sets the doc of the route for
+// the OpenAPI document
+func (g *Group) AddHandlerWithDoc(method string, path string, doc RouteDoc,
+	handler http.HandlerFunc, routeMws ...Middleware) {
+	g.AddHandler(method, path, handler, routeMws...)
+	g.server.SetRouteDoc(method, g.fullPrefix()+path, doc)
+}
+
+// SetRouteDoc sets the doc of a route for the OpenAPI document,
+// path is the full pattern (included group prefix), ex: "/admin/users/:id".
+// The route must be added before, prefer AddHandlerWithDoc.
+func (s *Server) SetRouteDoc(method string, path string, doc RouteDoc) {
+	if !s.routes.hasRoute(method, path) {
+		log.Errorf("error SetRouteDoc: route %v %v was not added", method, path)
+		return
+	}
+	s.routes.mutex.Lock()
+	s.routes.docs[Route{Method: method, Path: path}] = doc
+	s.routes.mutex.Unlock()
+}
+
+// SetRouteDoc sets the doc of a route in this group,
+// path does not include the group prefix (same as the AddHandler path)
+func (g *Group) SetRouteDoc(method string, path string, doc RouteDoc) {
+	g.server.SetRouteDoc(method, g.fullPrefix()+path, doc)
+}
+
+// EnableOpenAPI registers GET /__openapi.json, it responds the OpenAPI 3
+// document of all routes (generated on every request so routes added later
+// are included)
+func (s *Server) EnableOpenAPI(info OpenAPIInfo) {
+	s.AddHandler("GET", "/__openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		bodyB, err := json.Marshal(s.OpenAPI(info))
+		if err != nil {
+			s.WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bodyB)
+	})
+}
+
+// OpenAPI returns the OpenAPI 3 document of routes that were added by
+// AddHandler, except internal routes (paths begin with "/__" or "/debug/").
+// Request and response schemas are generated from types in RouteDocs.
+func (s *Server) OpenAPI(info OpenAPIInfo) map[string]interface{} {
+	if info.Title == "" {
+		info.Title = "API"
+	}
+	if info.Version == "" {
+		info.Version = "1.0.0"
+	}
+	b := &schemaBuilder{components: make(map[string]interface{}),
+		names: make(map[reflect.Type]string)}
+	paths := make(map[string]interface{})
+	for _, path := range s.routes.paths() {
+		if strings.HasPrefix(path, "/__") || strings.HasPrefix(path, "/debug/") {
+			continue
+		}
+		operations := make(map[string]interface{})
+		for _, method := range s.routes.methods(path) {
+			s.routes.mutex.RLock()
+			doc := s.routes.docs[Route{Method: method, Path: path}]
+			s.routes.mutex.RUnlock()
+			operations[strings.ToLower(method)] = b.operation(path, doc)
+		}
+		paths[openAPIPath(path)] = operations
+	}
+	b.schema(reflect.TypeOf(ErrorResponse{}))
+	infoObj := map[string]interface{}{"title": info.Title, "version": info.Version}
+	if info.Description != "" {
+		infoObj["description"] = info.Description
+	}
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       infoObj,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.components},
+	}
+}
+
+var pathParamRegexp = regexp.MustCompile(`[:*]([^/]+)`)
+
+// openAPIPath converts a httprouter pattern to an OpenAPI path,
+// ex: "/match/:id" to "/match/{id}"
+func openAPIPath(pattern string) string {
+	return pathParamRegexp.ReplaceAllString(pattern, "{$1}")
+}
+
+// schemaBuilder generates JSON schemas from Go types, named struct types
+// are put in components and referenced by $ref
+type schemaBuilder struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+func (b *schemaBuilder) operation(path string, doc RouteDoc) map[string]interface{} {
+	op := make(map[string]interface{})
+	if doc.Summary != "" {
+		op["summary"] = doc.Summary
+	}
+	if doc.Description != "" {
+		op["description"] = doc.Description
+	}
+	if len(doc.Tags) > 0 {
+		op["tags"] = doc.Tags
+	}
+
+	// parameters from the request type override the default string params
+	params := make(map[string]map[string]interface{})
+	var paramKeys []string
+	addParam := func(in string, name string, param map[string]interface{}) {
+		key := in + ":" + name
+		if _, found := params[key]; !found {
+			paramKeys = append(paramKeys, key)
+		}
+		params[key] = param
+	}
+	for _, match := range pathParamRegexp.FindAllStringSubmatch(path, -1) {
+		addParam("path", match[1], map[string]interface{}{"name": match[1],
+			"in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string"}})
+	}
+	if doc.Request != nil {
+		reqType := derefType(reflect.TypeOf(doc.Request))
+		if reqType.Kind() == reflect.Struct && reqType != timeType {
+			b.requestParams(reqType, addParam)
+			body := b.bodySchema(reqType)
+			if len(body["properties"].(map[string]interface{})) > 0 {
+				op["requestBody"] = jsonContent(body)
+			}
+		} else {
+			op["requestBody"] = jsonContent(b.schema(reqType))
+		}
+	}
+	if len(paramKeys) > 0 {
+		list := make([]interface{}, 0, len(paramKeys))
+		for _, key := range paramKeys {
+			list = append(list, params[key])
+		}
+		op["parameters"] = list
+	}
+
+	okResponse := map[string]interface{}{"description": "OK"}
+	if doc.Response != nil {
+		okResponse = jsonContent(b.schema(reflect.TypeOf(doc.Response)))
+		okResponse["description"] = "OK"
+	}
+	errResponse := jsonContent(map[string]interface{}{
+		"$ref": "#/components/schemas/ErrorResponse"})
+	errResponse["description"] = "Error"
+	op["responses"] = map[string]interface{}{"200": okResponse, "default": errResponse}
+	return op
+}
+
+// jsonContent returns a request body or response object that has the schema
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"content": map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema}}}
+}
+
+// requestParams adds fields that have tag path, query or header as parameters
+func (b *schemaBuilder) requestParams(t reflect.Type,
+	addParam func(in string, name string, param map[string]interface{})) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && derefType(field.Type).Kind() == reflect.Struct {
+			b.requestParams(derefType(field.Type), addParam)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		for _, tag := range bindTags {
+			name := field.Tag.Get(tag)
+			if name == "" || name == "-" {
+				continue
+			}
+			schema := b.schema(field.Type)
+			isRequired := applyValidateTag(schema, field)
+			param := map[string]interface{}{"name": name, "in": tag,
+				"required": isRequired || tag == "path", "schema": schema}
+			addParam(tag, name, param)
+		}
+	}
+}
+
+// bodySchema returns the object schema of the request fields that are not
+// parameters
+func (b *schemaBuilder) bodySchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	b.addProperties(t, properties, &required, true)
+	return objectSchema(properties, required)
+}
+
+func objectSchema(properties map[string]interface{}, required []string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addProperties adds JSON fields of struct t to properties,
+// fields of embedded structs are flattened same as encoding/json
+func (b *schemaBuilder) addProperties(t reflect.Type, properties map[string]interface{},
+	required *[]string, isSkipParams bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		name := strings.Split(jsonTag, ",")[0]
+		if field.Anonymous && name == "" && derefType(field.Type).Kind() == reflect.Struct {
+			b.addProperties(derefType(field.Type), properties, required, isSkipParams)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if isSkipParams && isParamField(field) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := b.schema(field.Type)
+		if _, isRef := schema["$ref"]; isRef && field.Tag.Get("validate") != "" {
+			// siblings of $ref are ignored in OpenAPI 3.0
+			schema = map[string]interface{}{"allOf": []interface{}{schema}}
+		}
+		if applyValidateTag(schema, field) {
+			*required = append(*required, name)
+		}
+		properties[name] = schema
+	}
+}
+
+func isParamField(field reflect.StructField) bool {
+	for _, tag := range bindTags {
+		if name := field.Tag.Get(tag); name != "" && name != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schema returns the JSON schema of a type
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	t = derefType(t)
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 { // encoding/json uses base64
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object",
+			"additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			properties := make(map[string]interface{})
+			var required []string
+			b.addProperties(t, properties, &required, false)
+			return objectSchema(properties, required)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + b.component(t)}
+	}
+	return map[string]interface{}{} // any type, ex: interface{}
+}
+
+// component adds the named struct type to components if it was not added,
+// returns the component name
+func (b *schemaBuilder) component(t reflect.Type) string {
+	if name, found := b.names[t]; found {
+		return name
+	}
+	name := t.Name()
+	if _, found := b.components[name]; found { // same name in other package
+		name = strings.Replace(t.String(), ".", "_", -1)
+	}
+	// name is set before generating properties for recursive types
+	b.names[t] = name
+	b.components[name] = nil
+	properties := make(map[string]interface{})
+	var required []string
+	b.addProperties(t, properties, &required, false)
+	b.components[name] = objectSchema(properties, required)
+	return name
+}
+
+// applyValidateTag documents validate rules of the field in schema,
+// returns true if the field is required
+func applyValidateTag(schema map[string]interface{}, field reflect.StructField) bool {
+	rules := field.Tag.Get("validate")
+	if rules == "" || rules == "-" {
+		return false
+	}
+	kind := derefType(field.Type).Kind()
+	isRequired := false
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		key, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, arg = rule[:i], rule[i+1:]
+		}
+		switch key {
+		case "required":
+			isRequired = true
+		case "min", "max", "len":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			var minName, maxName string
+			switch kind {
+			case reflect.String:
+				minName, maxName = "minLength", "maxLength"
+			case reflect.Slice, reflect.Array:
+				minName, maxName = "minItems", "maxItems"
+			case reflect.Map:
+				minName, maxName = "minProperties", "maxProperties"
+			default:
+				minName, maxName = "minimum", "maximum"
+			}
+			if key == "min" || key == "len" {
+				schema[minName] = limit
+			}
+			if key == "max" || key == "len" {
+				schema[maxName] = limit
+			}
+		case "email":
+			schema["format"] = "email"
+		case "oneof":
+			var enum []interface{}
+			for _, option := range strings.Fields(arg) {
+				if kind == reflect.String {
+					enum = append(enum, option)
+				} else if f, err := strconv.ParseFloat(option, 64); err == nil {
+					enum = append(enum, f)
+				}
+			}
+			schema["enum"] = enum
+		}
+	}
+	return isRequired
+}
@@ -0,0 +1,165 @@
+package httpsvr
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type openAPITestAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type openAPITestUser struct {
+	Id        int64     `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+	Friends   []*openAPITestUser
+	secret    string
+}
+
+type openAPITestUpdateUser struct {
+	Id      int64               `path:"id"`
+	Fields  []string            `query:"fields"`
+	Token   string              `header:"X-Token" validate:"required"`
+	Email   string              `json:"email" validate:"required,email"`
+	Role    string              `json:"role" validate:"oneof=admin user"`
+	Age     int                 `json:"age" validate:"min=1,max=150"`
+	Address *openAPITestAddress `json:"address" validate:"required"`
+	Ignored string              `json:"-"`
+}
+
+// lookup returns the value at the path in a decoded JSON,
+// ex: lookup(doc, "paths", "/users/{id}", "put")
+func lookup(obj interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = m[key]
+	}
+	return obj
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := NewServer()
+	s.EnableOpenAPI(OpenAPIInfo{Title: "Users", Version: "2.0"})
+	s.EnableAdminEndpoints(AdminConfig{IsEnableHealth: true})
+	api := s.Group("/api")
+	api.AddHandlerWithDoc("PUT", "/users/:id", RouteDoc{Summary: "update a user",
+		Tags: []string{"user"}, Request: openAPITestUpdateUser{},
+		Response: &openAPITestUser{}}, ExampleHandler())
+	api.AddHandler("GET", "/users/:id", ExampleHandler())
+	s.AddHandler("GET", "/files/*filepath", ExampleHandler())
+	// a doc of a not added route is not in the document
+	api.SetRouteDoc("GET", "/users/:userId", RouteDoc{Summary: "typo"})
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/__openapi.json", nil))
+	if w.Code != 200 || !strings.Contains(w.Header().Get("Content-Type"), "json") {
+		t.Fatalf("unexpected response %v: %v", w.Code, w.Body.String())
+	}
+	var doc map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	var pathNames []string
+	for path := range paths {
+		pathNames = append(pathNames, path)
+	}
+	if len(paths) != 2 || paths["/api/users/{id}"] == nil || paths["/files/{filepath}"] == nil {
+		t.Errorf("unexpected paths %v", pathNames)
+	}
+	if lookup(doc, "info", "title") != "Users" || lookup(doc, "openapi") != "3.0.3" {
+		t.Errorf("unexpected info %v", doc["info"])
+	}
+
+	get := lookup(paths, "/api/users/{id}", "get")
+	params := lookup(get, "parameters").([]interface{})
+	if len(params) != 1 || lookup(params[0], "name") != "id" ||
+		lookup(params[0], "schema", "type") != "string" {
+		t.Errorf("unexpected default params %v", params)
+	}
+
+	put := lookup(paths, "/api/users/{id}", "put")
+	if lookup(put, "summary") != "update a user" {
+		t.Errorf("unexpected summary %v", lookup(put, "summary"))
+	}
+	params = lookup(put, "parameters").([]interface{})
+	type param struct {
+		Name, In, Type string
+		Required       bool
+	}
+	var gotParams []param
+	for _, p := range params {
+		gotParams = append(gotParams, param{Name: lookup(p, "name").(string),
+			In: lookup(p, "in").(string), Type: lookup(p, "schema", "type").(string),
+			Required: lookup(p, "required").(bool)})
+	}
+	expectedParams := []param{{"id", "path", "integer", true},
+		{"fields", "query", "array", false}, {"X-Token", "header", "string", true}}
+	if !reflect.DeepEqual(gotParams, expectedParams) {
+		t.Errorf("params: expected %v, got %v", expectedParams, gotParams)
+	}
+
+	body := lookup(put, "requestBody", "content", "application/json", "schema")
+	properties := lookup(body, "properties").(map[string]interface{})
+	if len(properties) != 4 {
+		t.Errorf("unexpected body properties %v", properties)
+	}
+	if !reflect.DeepEqual(lookup(body, "required"), []interface{}{"email", "address"}) {
+		t.Errorf("unexpected required %v", lookup(body, "required"))
+	}
+	for _, c := range []struct {
+		keys     []string
+		expected interface{}
+	}{
+		{[]string{"email", "format"}, "email"},
+		{[]string{"role", "enum"}, []interface{}{"admin", "user"}},
+		{[]string{"age", "minimum"}, 1.0},
+		{[]string{"age", "maximum"}, 150.0},
+	} {
+		if got := lookup(properties, c.keys...); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.keys, c.expected, got)
+		}
+	}
+
+	resSchema := lookup(put, "responses", "200", "content", "application/json", "schema", "$ref")
+	if resSchema != "#/components/schemas/openAPITestUser" {
+		t.Errorf("unexpected response schema %v", resSchema)
+	}
+	schemas := lookup(doc, "components", "schemas")
+	userProps := lookup(schemas, "openAPITestUser", "properties").(map[string]interface{})
+	if len(userProps) != 4 ||
+		lookup(userProps, "createdAt", "format") != "date-time" ||
+		lookup(userProps, "Friends", "items", "$ref") != "#/components/schemas/openAPITestUser" {
+		t.Errorf("unexpected user schema %v", userProps)
+	}
+	if !reflect.DeepEqual(lookup(schemas, "openAPITestAddress", "required"),
+		[]interface{}{"city"}) {
+		t.Errorf("unexpected address schema %v", lookup(schemas, "openAPITestAddress"))
+	}
+	if lookup(schemas, "ErrorResponse") == nil {
+		t.Errorf("missing ErrorResponse schema")
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	for _, c := range []struct{ pattern, expected string }{
+		{"/match/:id", "/match/{id}"},
+		{"/users/:uid/posts/:pid", "/users/{uid}/posts/{pid}"},
+		{"/static/*filepath", "/static/{filepath}"},
+		{"/health", "/health"},
+	} {
+		if got := openAPIPath(c.pattern); got != c.expected {
+			t.Errorf("openAPIPath(%v): expected %v, got %v", c.pattern, c.expected, got)
+		}
+	}
+}
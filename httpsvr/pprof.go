@@ -0,0 +1,153 @@
+package httpsvr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handlePprof serves profiles in the format of net/http/pprof (so they can be
+// read by `go tool pprof`), it uses runtime/pprof directly because importing
+// net/http/pprof registers its handlers on http.DefaultServeMux
+func handlePprof() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(GetUrlParams(r)["name"], "/")
+		switch name {
+		case "":
+			pprofIndex(w)
+		case "cmdline":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+		case "profile":
+			pprofCPU(w, r)
+		case "trace":
+			pprofTrace(w, r)
+		case "symbol":
+			pprofSymbol(w, r)
+		default: // named profiles, ex: /debug/pprof/heap
+			pprofNamed(w, r, name)
+		}
+	}
+}
+
+// pprofIndex lists available profiles and their counts
+func pprofIndex(w http.ResponseWriter) {
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name() < profiles[j].Name()
+	})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, p := range profiles {
+		fmt.Fprintf(w, "%v\t%v\n", p.Count(), p.Name())
+	}
+	fmt.Fprint(w, "\ncmdline\nprofile?seconds=30\nsymbol\ntrace?seconds=1\n")
+}
+
+// pprofSeconds returns the query param "seconds" or the default
+func pprofSeconds(r *http.Request, defaultSeconds int) time.Duration {
+	seconds, err := strconv.Atoi(r.FormValue("seconds"))
+	if err != nil || seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// sleepOrDone returns early if the client went away
+func sleepOrDone(r *http.Request, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+func pprofCPU(w http.ResponseWriter, r *http.Request) {
+	duration := pprofSeconds(r, 30)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		WriteError(w, r, NewError(http.StatusInternalServerError,
+			fmt.Sprintf("error start CPU profile: %v", err)))
+		return
+	}
+	sleepOrDone(r, duration)
+	pprof.StopCPUProfile()
+}
+
+func pprofTrace(w http.ResponseWriter, r *http.Request) {
+	duration := pprofSeconds(r, 1)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		WriteError(w, r, NewError(http.StatusInternalServerError,
+			fmt.Sprintf("error start trace: %v", err)))
+		return
+	}
+	sleepOrDone(r, duration)
+	trace.Stop()
+}
+
+// pprofSymbol maps program counters (POST body or query, separated by "+")
+// to function names, it is used by `go tool pprof` for remote profiles
+func pprofSymbol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	buf := &bytes.Buffer{}
+	fmt.Fprint(buf, "num_symbols: 1\n")
+	var reader *bufio.Reader
+	if r.Method == "POST" {
+		body, _ := ioutil.ReadAll(r.Body)
+		reader = bufio.NewReader(bytes.NewReader(body))
+	} else {
+		reader = bufio.NewReader(strings.NewReader(r.URL.RawQuery))
+	}
+	for {
+		word, err := reader.ReadSlice('+')
+		if err == nil {
+			word = word[:len(word)-1] // trim the "+"
+		}
+		pc, _ := strconv.ParseUint(string(word), 0, 64)
+		if pc != 0 {
+			if f := runtime.FuncForPC(uintptr(pc)); f != nil {
+				fmt.Fprintf(buf, "%#x %s\n", pc, f.Name())
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	w.Write(buf.Bytes())
+}
+
+// pprofNamed writes a profile of pprof_Lookup, query param "debug=1" writes
+// text instead of the binary format, "gc=1" runs GC before a heap profile
+func pprofNamed(w http.ResponseWriter, r *http.Request, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		WriteError(w, r, NewError(http.StatusNotFound,
+			fmt.Sprintf("unknown profile %v", name)))
+		return
+	}
+	if name == "heap" && r.FormValue("gc") != "" && r.FormValue("gc") != "0" {
+		runtime.GC()
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="%v"`, name))
+	}
+	p.WriteTo(w, debug)
+}
@@ -0,0 +1,190 @@
+package httpsvr
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daominah/gomicrokit/auth/jwt"
+	"github.com/daominah/gomicrokit/metric"
+)
+
+// KeyFunc returns the client key of a request, requests that have a same key
+// share a same rate limit
+type KeyFunc func(r *http.Request) string
+
+// KeyByRemoteIP returns IP of the direct client
+func KeyByRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByForwardedFor returns the first IP in header X-Forwarded-For,
+// returns the remote IP if the header is empty.
+// Only use this func if the server is behind a trusted proxy,
+// because a client can send a fake header.
+func KeyByForwardedFor(r *http.Request) string {
+	forwarded := r.Header.Get("X-Forwarded-For")
+	if forwarded == "" {
+		return KeyByRemoteIP(r)
+	}
+	return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+}
+
+// KeyByJWT returns a KeyFunc that reads the field of the auth info in the
+// bearer token (header Authorization), ex: field "UserId",
+// returns the remote IP if the token is invalid.
+func KeyByJWT(jwter *jwt.JWTer, field string) KeyFunc {
+	return func(r *http.Request) string {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var authInfo map[string]interface{}
+		err := jwter.CheckAuthToken(token, &authInfo)
+		if err != nil || authInfo[field] == nil {
+			return KeyByRemoteIP(r)
+		}
+		return fmt.Sprintf("%v:%v", field, authInfo[field])
+	}
+}
+
+// RateLimitConfig configs a token bucket rate limiter
+type RateLimitConfig struct {
+	// Rate is number of requests per second a client can make on average
+	Rate float64
+	// Burst is the max number of requests a client can make at once,
+	// default is Rate (min 1)
+	Burst int
+	// KeyFunc identifies a client, default KeyByRemoteIP
+	KeyFunc KeyFunc
+}
+
+// RateLimitMiddleware limits number of requests per client by token bucket
+// algorithm, responds 429 with header Retry-After if a client exceeded limit.
+// Limited requests are counted in m (key "path_method_ratelimited", m can be nil).
+// It panics if conf_Rate is not a positive number.
+func RateLimitMiddleware(conf RateLimitConfig, m metric.Metric) Middleware {
+	if !(conf.Rate > 0) || math.IsInf(conf.Rate, 1) {
+		panic(fmt.Sprintf("RateLimitConfig Rate must be a positive number, got %v",
+			conf.Rate))
+	}
+	keyFunc := conf.KeyFunc
+	if keyFunc == nil {
+		keyFunc = KeyByRemoteIP
+	}
+	burst := float64(conf.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, conf.Rate)
+	}
+	limiter := newRateLimiter(conf.Rate, burst)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			isAllowed, wait := limiter.allow(keyFunc(r), time.Now())
+			if !isAllowed {
+				if m != nil {
+					m.Count(GetRoute(r).MetricKey() + "_ratelimited")
+				}
+				writeTooManyRequests(w, r, wait)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// ConcurrencyLimitMiddleware limits number of in-flight requests, usually
+// it is a route middleware, ex: s.AddHandler("POST", "/report", h, limit10).
+// Responds 429 if there are maxInFlight running requests.
+// Limited requests are counted in m (key "path_method_concurrencylimited").
+func ConcurrencyLimitMiddleware(maxInFlight int, m metric.Metric) Middleware {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	semaphore := make(chan bool, maxInFlight)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case semaphore <- true:
+				defer func() { <-semaphore }()
+				next(w, r)
+			default:
+				if m != nil {
+					m.Count(GetRoute(r).MetricKey() + "_concurrencylimited")
+				}
+				writeTooManyRequests(w, r, time.Second)
+			}
+		}
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", fmt.Sprintf("%v", seconds))
+	WriteError(w, r, NewError(http.StatusTooManyRequests,
+		http.StatusText(http.StatusTooManyRequests)))
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// rateLimiter holds a token bucket for each key
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mutex     *sync.Mutex
+}
+
+func newRateLimiter(rate float64, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, lastSweep: time.Now(),
+		buckets: make(map[string]*tokenBucket), mutex: &sync.Mutex{}}
+}
+
+// allow takes a token from the key's bucket, if the bucket is empty, returns
+// false and the duration until a token is available
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, lastRefill: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastRefill).Seconds()*l.rate)
+	b.lastRefill = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep deletes buckets that have been refilled fully so the map does not
+// grow forever, do not lock mutex in this func
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute || l.rate <= 0 {
+		return
+	}
+	l.lastSweep = now
+	fullDuration := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.lastRefill) > fullDuration {
+			delete(l.buckets, key)
+		}
+	}
+}
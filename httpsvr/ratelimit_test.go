@@ -0,0 +1,107 @@
+package httpsvr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 2)
+	now := time.Now()
+	for i, c := range []struct {
+		key       string
+		after     time.Duration
+		isAllowed bool
+	}{
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false},
+		{"b", 0, true},
+		{"a", 500 * time.Millisecond, true},
+		{"a", 500 * time.Millisecond, false},
+		{"a", 2 * time.Second, true},
+		{"a", 2 * time.Second, true},
+		{"a", 2 * time.Second, false},
+	} {
+		isAllowed, wait := l.allow(c.key, now.Add(c.after))
+		if isAllowed != c.isAllowed {
+			t.Errorf("case %v: isAllowed %v, expect %v", i, isAllowed, c.isAllowed)
+		}
+		if !isAllowed && (wait <= 0 || wait > 500*time.Millisecond) {
+			t.Errorf("case %v: unexpected wait %v", i, wait)
+		}
+	}
+	l.allow("c", now.Add(2*time.Minute))
+	if len(l.buckets) != 1 {
+		t.Errorf("idle buckets were not swept: %v", len(l.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	s := NewServer()
+	s.AddHandler("GET", "/", ExampleHandler(), RateLimitMiddleware(
+		RateLimitConfig{Rate: 0.1, Burst: 2, KeyFunc: KeyByForwardedFor}, s.Metric))
+	var codes []int
+	for _, ip := range []string{"1.1.1.1", "1.1.1.1", "1.1.1.1", "2.2.2.2"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Forwarded-For", ip+", 10.0.0.1")
+		s.router.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "10" {
+			t.Errorf("unexpected Retry-After %v", w.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 || codes[3] != 200 {
+		t.Errorf("unexpected status codes %v", codes)
+	}
+	isCounted := false
+	for _, row := range s.Metric.GetCurrentMetric() {
+		if row.Key == "/_GET_ratelimited" && row.Count == 1 {
+			isCounted = true
+		}
+	}
+	if !isCounted {
+		t.Errorf("limited request was not counted: %v", s.Metric.GetCurrentMetric())
+	}
+}
+
+func TestRateLimitInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for rate %v", rate)
+				}
+			}()
+			RateLimitMiddleware(RateLimitConfig{Rate: rate}, nil)
+		}()
+	}
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	s := NewServer()
+	release := make(chan bool)
+	started := make(chan bool)
+	s.AddHandler("GET", "/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	}, ConcurrencyLimitMiddleware(1, s.Metric))
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-started
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("unexpected response %v", w.Code)
+	}
+	close(release)
+	wg.Wait()
+}
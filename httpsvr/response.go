@@ -0,0 +1,107 @@
+package httpsvr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter wraps a http_ResponseWriter to capture the status code,
+// number of bytes written and time to first byte of the response.
+// It implements http_Flusher and http_Hijacker if the wrapped writer does.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	beginTime   time.Time
+	firstByteAt time.Time
+}
+
+// NewResponseWriter returns w if w is already a *ResponseWriter,
+// so all middlewares share a same captured status
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	if rw, ok := w.(*ResponseWriter); ok {
+		return rw
+	}
+	return &ResponseWriter{ResponseWriter: w, beginTime: time.Now()}
+}
+
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+		w.firstByteAt = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+		w.firstByteAt = time.Now()
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Status returns the responded status code,
+// returns 200 if the handler has not written anything
+func (w *ResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Size returns number of body bytes written
+func (w *ResponseWriter) Size() int {
+	return w.size
+}
+
+// TimeToFirstByte returns duration from the writer was created to the header
+// was written, returns 0 if the handler has not written anything
+func (w *ResponseWriter) TimeToFirstByte() time.Duration {
+	if w.firstByteAt.IsZero() {
+		return 0
+	}
+	return w.firstByteAt.Sub(w.beginTime)
+}
+
+// Flush implements http_Flusher, used by streaming responses
+func (w *ResponseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+		w.firstByteAt = time.Now()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http_Hijacker, used by websocket upgrader
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+		w.firstByteAt = time.Now()
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer, used by http_ResponseController
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+var errHijackNotSupported = errors.New("http.Hijacker is not supported")
+
+// StatusClass returns "1xx", "2xx", "3xx", "4xx" or "5xx"
+func StatusClass(statusCode int) string {
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
@@ -0,0 +1,304 @@
+package httpsvr
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daominah/gomicrokit/metric"
+)
+
+// default values of SSEConfig
+const (
+	DefaultSSEClientBufferSize = 64
+	DefaultSSEReplaySize       = 256
+	DefaultSSEHeartbeat        = 15 * time.Second
+)
+
+// SSEConfig configs a SSEBroker
+type SSEConfig struct {
+	// ClientBufferSize is the number of events buffered for each client,
+	// a client that is too slow to read (the buffer is full) is disconnected,
+	// default DefaultSSEClientBufferSize
+	ClientBufferSize int
+	// ReplaySize is the number of recent events kept for each topic, they are
+	// resent to a reconnected client that has header Last-Event-ID,
+	// default DefaultSSEReplaySize
+	ReplaySize int
+	// HeartbeatInterval is the interval of writing a comment line to keep
+	// the connection alive through proxies, default DefaultSSEHeartbeat
+	HeartbeatInterval time.Duration
+	// TopicsFunc returns the topics that the request subscribes,
+	// default is values of the query param "topic", ex: /events?topic=a&topic=b
+	TopicsFunc func(r *http.Request) []string
+}
+
+// SSEEvent is a Server-Sent Event
+type SSEEvent struct {
+	// Id is set by SSEBroker_Publish to an increasing sequence number
+	Id string
+	// Event is the event type, empty means "message"
+	Event string
+	// Data can have multiple lines
+	Data string
+	// Retry tells the client the reconnection time, 0 means not set
+	Retry time.Duration
+}
+
+// SSEBroker delivers events published to topics to subscribed clients,
+// its Handler should be registered by AddHandler, ex:
+//
+//	broker := NewSSEBroker(SSEConfig{}, s.Metric)
+//	s.AddHandler("GET", "/events", broker.Handler())
+//	s.OnShutdownStart(broker.Close)
+//	broker.Publish("news", SSEEvent{Data: "hello"})
+type SSEBroker struct {
+	conf     SSEConfig
+	metric   metric.Metric
+	seq      uint64
+	clients  map[*sseClient]bool
+	replays  map[string][]sseItem
+	isClosed bool
+	mutex    *sync.Mutex
+}
+
+// sseItem is an event in the replay buffer of a topic
+type sseItem struct {
+	seq   uint64
+	event SSEEvent
+}
+
+type sseClient struct {
+	topics map[string]bool
+	events chan SSEEvent
+	// done is closed when the broker disconnects the client
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *sseClient) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// NewSSEBroker returns a broker, m observes clients (can be nil), keys are
+// "path_method_ssesession" (count and duration of finished connections) and
+// "path_method_ssedropped" (clients disconnected because they were too slow)
+func NewSSEBroker(conf SSEConfig, m metric.Metric) *SSEBroker {
+	if conf.ClientBufferSize <= 0 {
+		conf.ClientBufferSize = DefaultSSEClientBufferSize
+	}
+	if conf.ReplaySize <= 0 {
+		conf.ReplaySize = DefaultSSEReplaySize
+	}
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = DefaultSSEHeartbeat
+	}
+	if conf.TopicsFunc == nil {
+		conf.TopicsFunc = func(r *http.Request) []string { return r.URL.Query()["topic"] }
+	}
+	return &SSEBroker{
+		conf:    conf,
+		metric:  m,
+		clients: make(map[*sseClient]bool),
+		replays: make(map[string][]sseItem),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// Publish sends the event to all clients that subscribed the topic,
+// it does not block on slow clients
+func (b *SSEBroker) Publish(topic string, event SSEEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.seq++
+	event.Id = strconv.FormatUint(b.seq, 10)
+	replay := append(b.replays[topic], sseItem{seq: b.seq, event: event})
+	if len(replay) > b.conf.ReplaySize {
+		replay = replay[len(replay)-b.conf.ReplaySize:]
+	}
+	b.replays[topic] = replay
+	for client := range b.clients {
+		if !client.topics[topic] {
+			continue
+		}
+		select {
+		case client.events <- event:
+		default: // the client is too slow
+			delete(b.clients, client)
+			client.close()
+		}
+	}
+}
+
+// NumClients returns number of connected clients that subscribed the topic,
+// empty topic means all clients
+func (b *SSEBroker) NumClients(topic string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if topic == "" {
+		return len(b.clients)
+	}
+	n := 0
+	for client := range b.clients {
+		if client.topics[topic] {
+			n++
+		}
+	}
+	return n
+}
+
+// Close disconnects all clients, new requests will be responded 503
+func (b *SSEBroker) Close() {
+	b.mutex.Lock()
+	b.isClosed = true
+	for client := range b.clients {
+		client.close()
+	}
+	b.clients = make(map[*sseClient]bool)
+	b.mutex.Unlock()
+}
+
+// subscribe returns nil if the broker was closed, the returned events are
+// buffered events after lastEventId
+func (b *SSEBroker) subscribe(topics []string, lastEventId string) (
+	*sseClient, []SSEEvent) {
+	client := &sseClient{topics: make(map[string]bool),
+		events: make(chan SSEEvent, b.conf.ClientBufferSize),
+		done:   make(chan struct{})}
+	for _, topic := range topics {
+		client.topics[topic] = true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.isClosed {
+		return nil, nil
+	}
+	b.clients[client] = true
+	if lastEventId == "" {
+		return client, nil
+	}
+	lastSeq, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		return client, nil
+	}
+	var items []sseItem
+	for topic := range client.topics {
+		replay := b.replays[topic]
+		i := sort.Search(len(replay), func(i int) bool { return replay[i].seq > lastSeq })
+		items = append(items, replay[i:]...)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].seq < items[j].seq })
+	events := make([]SSEEvent, len(items))
+	for i, item := range items {
+		events[i] = item.event
+	}
+	return client, events
+}
+
+func (b *SSEBroker) unsubscribe(client *sseClient) (isDropped bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, found := b.clients[client]
+	delete(b.clients, client)
+	client.close()
+	return !found && !b.isClosed
+}
+
+// Handler streams events of topics (returned by SSEConfig_TopicsFunc) to
+// the client, responds 400 if the request does not have any topic
+func (b *SSEBroker) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			WriteError(w, r, NewError(http.StatusInternalServerError,
+				"streaming is not supported"))
+			return
+		}
+		topics := b.conf.TopicsFunc(r)
+		if len(topics) == 0 {
+			WriteError(w, r, NewError(http.StatusBadRequest, "missing topic"))
+			return
+		}
+		client, replay := b.subscribe(topics, r.Header.Get("Last-Event-ID"))
+		if client == nil {
+			WriteError(w, r, NewError(http.StatusServiceUnavailable,
+				"server is shutting down"))
+			return
+		}
+		beginTime := time.Now()
+		defer func() {
+			isDropped := b.unsubscribe(client)
+			if b.metric == nil {
+				return
+			}
+			key := GetRoute(r).MetricKey()
+			b.metric.Count(key + "_ssesession")
+			b.metric.Duration(key+"_ssesession", time.Since(beginTime))
+			if isDropped {
+				b.metric.Count(key + "_ssedropped")
+			}
+		}()
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Connection", "keep-alive")
+		h.Set("X-Accel-Buffering", "no") // disable nginx buffering
+		w.WriteHeader(http.StatusOK)
+		for _, event := range replay {
+			if writeSSEEvent(w, event) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(b.conf.HeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-client.done:
+				return
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			case event := <-client.events:
+				err = writeSSEEvent(w, event)
+				for i := len(client.events); i > 0 && err == nil; i-- {
+					err = writeSSEEvent(w, <-client.events)
+				}
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent writes the event in text/event-stream format
+func writeSSEEvent(w http.ResponseWriter, event SSEEvent) error {
+	var buf strings.Builder
+	if event.Id != "" {
+		fmt.Fprintf(&buf, "id: %v\n", event.Id)
+	}
+	if event.Event != "" {
+		fmt.Fprintf(&buf, "event: %v\n", strings.NewReplacer(
+			"\r", "", "\n", "").Replace(event.Event))
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %v\n", int64(event.Retry/time.Millisecond))
+	}
+	data := strings.Replace(event.Data, "\r\n", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&buf, "data: %v\n", line)
+	}
+	buf.WriteString("\n")
+	_, err := w.Write([]byte(buf.String()))
+	return err
+}
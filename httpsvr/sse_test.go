@@ -0,0 +1,131 @@
+package httpsvr
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daominah/gomicrokit/metric"
+)
+
+// readSSEEvents reads n events (comments are skipped) from a stream
+func readSSEEvents(t *testing.T, reader *bufio.Reader, n int) []SSEEvent {
+	var events []SSEEvent
+	var event SSEEvent
+	var dataLines []string
+	for len(events) < n {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error read stream: %v, got events %v", err, events)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if event.Id != "" || len(dataLines) > 0 {
+				event.Data = strings.Join(dataLines, "\n")
+				events = append(events, event)
+			}
+			event, dataLines = SSEEvent{}, nil
+		case strings.HasPrefix(line, "id: "):
+			event.Id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			event.Event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			dataLines = append(dataLines, line[6:])
+		}
+	}
+	return events
+}
+
+func TestSSE(t *testing.T) {
+	s := NewServer()
+	m := metric.NewMemoryMetric()
+	broker := NewSSEBroker(SSEConfig{ReplaySize: 2, HeartbeatInterval: 20 * time.Millisecond}, m)
+	s.AddHandler("GET", "/events", broker.Handler())
+	ts := httptest.NewServer(s.router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events?topic=news&topic=sport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %v %v", resp.StatusCode, resp.Header)
+	}
+	for i := 0; i < 100 && broker.NumClients("news") != 1; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	broker.Publish("news", SSEEvent{Event: "headline", Data: "line1\nline2"})
+	broker.Publish("weather", SSEEvent{Data: "not subscribed"})
+	broker.Publish("sport", SSEEvent{Data: "goal"})
+	broker.Publish("news", SSEEvent{Data: "third"})
+	reader := bufio.NewReader(resp.Body)
+	events := readSSEEvents(t, reader, 3)
+	expected := []SSEEvent{{Id: "1", Event: "headline", Data: "line1\nline2"},
+		{Id: "3", Data: "goal"}, {Id: "4", Data: "third"}}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %v: expected %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+	line, _ := reader.ReadString('\n') // waits for a heartbeat
+	if line != ": heartbeat\n" {
+		t.Errorf("expected heartbeat, got %q", line)
+	}
+
+	// resume: only the last 2 events of each topic are kept
+	broker.Publish("news", SSEEvent{Data: "fifth"})
+	req, _ := http.NewRequest("GET", ts.URL+"/events?topic=news&topic=sport", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	events = readSSEEvents(t, bufio.NewReader(resp2.Body), 3)
+	if events[0].Id != "3" || events[1].Id != "4" || events[2].Id != "5" {
+		t.Errorf("unexpected replayed events %+v", events)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/events", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for missing topic, got %v", w.Code)
+	}
+
+	broker.Close()
+	for _, body := range []*bufio.Reader{reader, bufio.NewReader(resp2.Body)} {
+		for {
+			if _, err := body.ReadString('\n'); err != nil {
+				break // the stream ended
+			}
+		}
+	}
+	if n := broker.NumClients(""); n != 0 {
+		t.Errorf("expected no client after Close, got %v", n)
+	}
+	rows := m.GetCurrentMetric()
+	if len(rows) != 1 || rows[0].Key != "/events_GET_ssesession" || rows[0].Count != 2 {
+		t.Errorf("unexpected metric %+v", rows)
+	}
+}
+
+func TestSSESlowClient(t *testing.T) {
+	broker := NewSSEBroker(SSEConfig{ClientBufferSize: 2}, nil)
+	client, _ := broker.subscribe([]string{"a"}, "")
+	for i := 0; i < 3; i++ {
+		broker.Publish("a", SSEEvent{Data: "x"})
+	}
+	select {
+	case <-client.done:
+	default:
+		t.Error("slow client must be disconnected")
+	}
+	if !broker.unsubscribe(client) || broker.NumClients("a") != 0 {
+		t.Error("slow client must be counted as dropped")
+	}
+}
@@ -0,0 +1,194 @@
+package httpsvr
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StaticConfig configs ServeDirWithConf
+type StaticConfig struct {
+	// Dir is the directory on disk contains files to serve
+	Dir string
+	// IndexFile is served for a directory, default "index.html"
+	IndexFile string
+	// IsListDir responds a list of files for a directory without IndexFile
+	IsListDir bool
+	// IsSPA (single-page app) responds the root IndexFile for paths that do
+	// not exist, so the client side router can handle them
+	IsSPA bool
+}
+
+// ServeDir serves files in dir at URL prefix, ex: ServeDir("/static", "./web"),
+// "/static/app.js" will respond file "./web/app.js".
+func (s *Server) ServeDir(prefix string, dir string) {
+	s.ServeDirWithConf(prefix, StaticConfig{Dir: dir})
+}
+
+// ServeDirWithConf serves files with ETag, Last-Modified and range requests
+// support. If a precompressed variant (file.br or file.gz) exists and the
+// client accepts its encoding, the variant will be responded.
+// Requests go through the server middlewares (log, metric, ..).
+// If prefix is "" or "/", files are served for paths that do not match any
+// route (via the router NotFound handler).
+func (s *Server) ServeDirWithConf(prefix string, conf StaticConfig) {
+	if conf.IndexFile == "" {
+		conf.IndexFile = "index.html"
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	handler := serveDir(conf)
+	if prefix != "" {
+		s.AddHandler("GET", prefix+"/*filepath", handler)
+		s.AddHandler("HEAD", prefix+"/*filepath", handler)
+		return
+	}
+	route := Route{Method: "GET", Path: "/*filepath"}
+	chain := newRouteChain(s.root, handler)
+	s.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			WriteError(w, r, NewError(http.StatusNotFound,
+				http.StatusText(http.StatusNotFound)))
+			return
+		}
+		ctx := context.WithValue(r.Context(), CtxRoute, route)
+		chain.handler()(w, r.WithContext(ctx))
+	})
+}
+
+func serveDir(conf StaticConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, found := GetUrlParams(r)["filepath"]
+		if !found {
+			name = r.URL.Path
+		}
+		// Clean with a leading slash removes ".." so name cannot be outside Dir
+		name = path.Clean("/" + name)
+		fullPath := filepath.Join(conf.Dir, filepath.FromSlash(name))
+		info, err := os.Stat(fullPath)
+		if err == nil && info.IsDir() {
+			indexPath := filepath.Join(fullPath, conf.IndexFile)
+			if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
+				serveStaticFile(w, r, indexPath)
+				return
+			}
+			if conf.IsListDir {
+				if !strings.HasSuffix(r.URL.Path, "/") { // for relative links
+					http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+					return
+				}
+				listDir(w, r, fullPath)
+				return
+			}
+			err = os.ErrNotExist
+		}
+		if err != nil {
+			if conf.IsSPA {
+				serveStaticFile(w, r, filepath.Join(conf.Dir, conf.IndexFile))
+				return
+			}
+			WriteError(w, r, NewError(http.StatusNotFound,
+				http.StatusText(http.StatusNotFound)))
+			return
+		}
+		serveStaticFile(w, r, fullPath)
+	}
+}
+
+// precompressedVariants are checked in order of preference
+var precompressedVariants = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// serveStaticFile responds the file or its precompressed variant
+func serveStaticFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	servedPath := filePath
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	for _, variant := range precompressedVariants {
+		info, err := os.Stat(filePath + variant.extension)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if servedPath == filePath && isAcceptedEncoding(acceptEncoding, variant.encoding) {
+			servedPath = filePath + variant.extension
+			w.Header().Set("Content-Encoding", variant.encoding)
+		}
+	}
+	f, err := os.Open(servedPath)
+	if err != nil {
+		w.Header().Del("Content-Encoding")
+		WriteError(w, r, NewError(http.StatusNotFound,
+			http.StatusText(http.StatusNotFound)))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	// Content-Type is detected by the original name instead of the variant
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	// ServeContent handles Last-Modified, If-None-Match, Range, ..
+	http.ServeContent(w, r, filePath, info.ModTime(), f)
+}
+
+// isAcceptedEncoding checks header Accept-Encoding, ex: "gzip, br;q=0.9"
+func isAcceptedEncoding(acceptEncoding string, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.Replace(param, " ", "", -1)
+			if param == "q=0" || param == "q=0.0" || param == "q=0.00" ||
+				param == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func listDir(w http.ResponseWriter, r *http.Request, dirPath string) {
+	infos, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<pre>\n")
+	for _, name := range names {
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%v\">%v</a>\n",
+			html.EscapeString(link.String()), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
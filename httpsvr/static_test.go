@@ -0,0 +1,123 @@
+package httpsvr
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newStaticDir returns a temp dir contains a public dir to serve and
+// a secret file outside the public dir
+func newStaticDir(t *testing.T) (tmpDir string, publicDir string) {
+	tmpDir, err := ioutil.TempDir("", "httpsvr_static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(tmpDir, "secret.txt"), []byte("x"), 0600)
+	dir := filepath.Join(tmpDir, "public")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0700)
+	for name, content := range map[string]string{
+		"index.html":    "<html>index</html>",
+		"app.js":        "console.log('hello world')",
+		"app.js.gz":     "fake gzip content",
+		"sub/data.json": `{"Data":"PONG"}`,
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+	}
+	return tmpDir, dir
+}
+
+func TestServeDir(t *testing.T) {
+	tmpDir, dir := newStaticDir(t)
+	defer os.RemoveAll(tmpDir)
+	s := NewServer()
+	s.ServeDirWithConf("/static", StaticConfig{Dir: dir, IsListDir: true})
+
+	do := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/static/app.js", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Body.String() != "console.log('hello world')" ||
+		etag == "" || w.Header().Get("Last-Modified") == "" ||
+		!strings.Contains(w.Header().Get("Content-Type"), "javascript") {
+		t.Errorf("unexpected response %v: %v %s", w.Code, w.Header(), w.Body)
+	}
+	if w := do("/static/app.js", map[string]string{"If-None-Match": etag}); w.Code != 304 {
+		t.Errorf("expected 304 but %v", w.Code)
+	}
+	if w := do("/static/app.js", map[string]string{"Range": "bytes=0-6"}); w.Code != 206 ||
+		w.Body.String() != "console" {
+		t.Errorf("unexpected range response %v: %s", w.Code, w.Body)
+	}
+	w = do("/static/app.js", map[string]string{"Accept-Encoding": "br;q=1, gzip"})
+	if w.Body.String() != "fake gzip content" ||
+		w.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.Contains(w.Header().Get("Content-Type"), "javascript") {
+		t.Errorf("unexpected precompressed response: %v %s", w.Header(), w.Body)
+	}
+	if w := do("/static/app.js", map[string]string{"Accept-Encoding": "gzip;q=0"}); w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("gzip was not accepted but %v", w.Header())
+	}
+	if w := do("/static/", nil); w.Body.String() != "<html>index</html>" {
+		t.Errorf("unexpected index: %v %s", w.Code, w.Body)
+	}
+	if w := do("/static/sub", nil); w.Code != http.StatusMovedPermanently {
+		t.Errorf("unexpected dir without slash: %v", w.Code)
+	}
+	if w := do("/static/sub/", nil); !strings.Contains(w.Body.String(), `href="data.json"`) {
+		t.Errorf("unexpected dir listing: %v %s", w.Code, w.Body)
+	}
+	if w := do("/static/../secret.txt", nil); w.Code != 404 {
+		t.Errorf("path traversal: %v %s", w.Code, w.Body)
+	}
+	if w := do("/static/missing.js", nil); w.Code != 404 {
+		t.Errorf("unexpected missing file: %v", w.Code)
+	}
+	isCounted := false
+	for _, row := range s.Metric.GetCurrentMetric() {
+		if row.Key == "/static/*filepath_GET_2xx" {
+			isCounted = true
+		}
+	}
+	if !isCounted {
+		t.Errorf("static requests did not go through metric middleware")
+	}
+}
+
+func TestServeDirSPA(t *testing.T) {
+	tmpDir, dir := newStaticDir(t)
+	defer os.RemoveAll(tmpDir)
+	s := NewServer()
+	s.AddHandler("GET", "/api/ping", ExampleHandler())
+	s.ServeDirWithConf("/", StaticConfig{Dir: dir, IsSPA: true})
+	for i, c := range []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{"GET", "/api/ping", 200, "PONG"},
+		{"GET", "/app.js", 200, "hello world"},
+		{"GET", "/users/119/profile", 200, "<html>index</html>"},
+		{"GET", "/sub", 200, "<html>index</html>"},
+		{"POST", "/users", 404, "Not Found"},
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("case %v: unexpected response %v: %s", i, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package httpsvr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/daominah/gomicrokit/log"
+)
+
+// DefaultCertReloadInterval is used if TLSConfig_ReloadInterval is not set
+const DefaultCertReloadInterval = 10 * time.Second
+
+// TLSConfig defines parameters for serving HTTPS
+type TLSConfig struct {
+	// CertFile and KeyFile are paths to a PEM encoded certificate and key,
+	// the files can be replaced while the server is running (ex: renewed
+	// by certbot), new connections will use the new certificate.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is an optional path to PEM encoded CA certificates,
+	// if set, clients must present a certificate signed by one of the CAs
+	// (mutual TLS)
+	ClientCAFile string
+	// ReloadInterval is the minimum duration between two checks for
+	// modification of CertFile and KeyFile, default DefaultCertReloadInterval
+	ReloadInterval time.Duration
+	// default the server supports both HTTP/2 and HTTP/1.1
+	IsDisableHTTP2 bool
+}
+
+// ListenAndServeTLS listens on the TCP network address addr and serves HTTPS,
+// certFile and keyFile will be reloaded if they are modified.
+func (s *Server) ListenAndServeTLS(addr string, certFile string, keyFile string) error {
+	return s.ListenAndServeTLSWithConf(addr,
+		TLSConfig{CertFile: certFile, KeyFile: keyFile})
+}
+
+// ListenAndServeTLSWithConf is ListenAndServeTLS with more configs,
+// ex: mutual TLS, disable HTTP/2.
+func (s *Server) ListenAndServeTLSWithConf(addr string, conf TLSConfig) error {
+	tlsConf, err := newServerTLSConfig(conf)
+	if err != nil {
+		return err
+	}
+	s.config.Addr = addr
+	s.config.TLSConfig = tlsConf
+	if conf.IsDisableHTTP2 {
+		// a non-nil empty map disables HTTP/2
+		s.config.TLSNextProto = make(
+			map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return s.config.ListenAndServeTLS("", "")
+}
+
+func newServerTLSConfig(conf TLSConfig) (*tls.Config, error) {
+	interval := conf.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultCertReloadInterval
+	}
+	reloader, err := newCertReloader(conf.CertFile, conf.KeyFile, interval)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if conf.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errInvalidClientCA
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConf, nil
+}
+
+var errInvalidClientCA = errors.New("client CA file has no valid PEM certificate")
+
+// certReloader reloads the certificate if the files were modified,
+// the check is done lazily when a client handshakes
+type certReloader struct {
+	certFile    string
+	keyFile     string
+	interval    time.Duration
+	cert        *tls.Certificate
+	modTime     time.Time // the latest modification time of the two files
+	lastChecked time.Time
+	mutex       *sync.Mutex
+}
+
+func newCertReloader(certFile string, keyFile string, interval time.Duration) (
+	*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile,
+		interval: interval, mutex: &sync.Mutex{}}
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	err = c.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) filesModTime() (time.Time, error) {
+	var ret time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return ret, err
+		}
+		if info.ModTime().After(ret) {
+			ret = info.ModTime()
+		}
+	}
+	return ret, nil
+}
+
+// do not lock mutex in this func
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("error load key pair: %v", err)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	c.lastChecked = time.Now()
+	return nil
+}
+
+// GetCertificate can be used as tls_Config_GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if time.Since(c.lastChecked) < c.interval {
+		return c.cert, nil
+	}
+	c.lastChecked = time.Now()
+	modTime, err := c.filesModTime()
+	if err != nil {
+		log.Infof("error when check certificate files: %v", err)
+		return c.cert, nil
+	}
+	if !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+	// keep using the old certificate if the new one is invalid,
+	// maybe the key file is being written
+	if err := c.load(modTime); err != nil {
+		log.Infof("error when reload certificate: %v", err)
+		return c.cert, nil
+	}
+	log.Infof("reloaded certificate %v", c.certFile)
+	return c.cert, nil
+}
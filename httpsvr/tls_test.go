@@ -0,0 +1,163 @@
+package httpsvr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// genCert writes a PEM certificate and key to dir, the certificate is
+// self-signed if parent is nil
+func genCert(t *testing.T, dir string, name string, serial int64,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (
+	*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent,
+		&key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpsvr_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	genCert(t, dir, "server", 1, nil, nil)
+
+	s := NewServer()
+	s.AddHandler("GET", "/", ExampleHandler())
+	addr := freeAddr(t)
+	go s.ListenAndServeTLSWithConf(addr, TLSConfig{
+		CertFile:       filepath.Join(dir, "server.crt"),
+		KeyFile:        filepath.Join(dir, "server.key"),
+		ReloadInterval: time.Millisecond,
+	})
+	defer s.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	get := func() (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		res, err := client.Get("https://" + addr + "/")
+		if err == nil {
+			res.Body.Close()
+		}
+		return res, err
+	}
+	res, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 but %v", res.Proto)
+	}
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 1 {
+		t.Errorf("unexpected serial %v", serial)
+	}
+
+	// replace certificate on disk, the server must use the new one
+	genCert(t, dir, "server", 2, nil, nil)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), future, future)
+	res, err = get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Errorf("certificate was not reloaded, serial %v", serial)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpsvr_mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := genCert(t, dir, "ca", 1, nil, nil)
+	genCert(t, dir, "server", 2, ca, caKey)
+	genCert(t, dir, "client", 3, ca, caKey)
+
+	s := NewServer()
+	s.AddHandler("GET", "/", ExampleHandler())
+	addr := freeAddr(t)
+	go s.ListenAndServeTLSWithConf(addr, TLSConfig{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	})
+	defer s.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(
+		filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []struct {
+		certs    []tls.Certificate
+		isFailed bool
+	}{
+		{nil, true},
+		{[]tls.Certificate{clientCert}, false},
+	} {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: c.certs},
+		}}
+		res, err := client.Get("https://" + addr + "/")
+		if err == nil {
+			res.Body.Close()
+		}
+		if (err != nil) != c.isFailed {
+			t.Errorf("case %v: unexpected error: %v", i, err)
+		}
+	}
+}
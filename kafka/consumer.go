@@ -55,6 +55,16 @@ type Message struct {
 	Timestamp time.Time
 }
 
+// Context returns a context that holds a logger has fields topic, partition
+// and offset of the message, ex: log.FromContext(msg.Context()).Infof("done"),
+// the context can be passed to funcs that process the message.
+// The logger is created on every call, so call it once per message.
+func (m Message) Context() context.Context {
+	ctx, _ := log.WithContext(context.Background(), "topic", m.Topic,
+		"partition", m.Partition, "offset", m.Offset)
+	return ctx
+}
+
 // Consumer _
 type Consumer struct {
 	client  sarama.ConsumerGroup
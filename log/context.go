@@ -0,0 +1,54 @@
+package log
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// ctxKeyType is used for avoiding context key conflict
+type ctxKeyType string
+
+// ctxLogger is the context key of the logger that has request scoped fields
+const ctxLogger ctxKeyType = "ctxLogger"
+
+// NewContext returns a copy of ctx that holds the logger,
+// the logger can be read by FromContext
+func NewContext(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, ctxLogger, logger)
+}
+
+// WithContext returns a copy of ctx that holds a child of the logger in ctx
+// with the key-value pairs added, the child logger is also returned.
+// Log lines of FromContext(ctx) of all funcs in the call chain have the
+// fields, ex:
+//
+//	ctx, logger := log.WithContext(ctx, "userId", userId)
+//	logger.Infof("user logged in")
+func WithContext(ctx context.Context, keysAndValues ...interface{}) (
+	context.Context, *zap.SugaredLogger) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := FromContext(ctx).With(keysAndValues...)
+	return NewContext(ctx, logger), logger
+}
+
+// FromContext returns the logger in ctx (ex: httpsvr puts a logger that has
+// field requestId in the request context), returns a logger that is same as
+// GlobalLogger if ctx does not have a logger.
+// Example: log.FromContext(r.Context()).Infof("user %v logged in", userId)
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(ctxLogger).(*zap.SugaredLogger); ok {
+			return logger
+		}
+	}
+	return directLogger()
+}
+
+// directLogger returns GlobalLogger without the caller skip for funcs of
+// this package, so the caller of its methods is logged correctly
+func directLogger() *zap.SugaredLogger {
+	return GlobalLogger.Desugar().WithOptions(zap.AddCallerSkip(-1)).Sugar()
+}
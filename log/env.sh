@@ -1,8 +1,28 @@
 # default log level is debug
 export LOG_LEVEL_INFO=false
+# "debug", "info", "warn" or "error", overrides LOG_LEVEL_INFO if not empty
+export LOG_LEVEL=
 # default (empty file path) log to stdout
 export LOG_FILE_PATH=
 # whether to log simultaneously to both stdout and file
 export LOG_NOT_STDOUT=false
 # whether to rotate log file at midnight
 export LOG_NOT_ROTATE=false
+# timezone that rotation times are aligned to, ex: Asia/Ho_Chi_Minh, default UTC
+export LOG_ROTATE_TIMEZONE=
+# max size in megabytes of the log file before it gets rotated, default 100
+export LOG_MAX_SIZE=
+# max number of rotated files to keep, empty means keep all
+export LOG_MAX_BACKUPS=
+# max number of days to keep rotated files, empty means keep all
+export LOG_MAX_AGE=
+# whether to gzip rotated files
+export LOG_COMPRESS=false
+# log format: "console" (default) or "json"
+export LOG_FORMAT=console
+# log sampling: first N lines per second per message template, then every
+# Mth line (default 100), empty means no sampling
+export LOG_SAMPLE_FIRST=
+export LOG_SAMPLE_THEREAFTER=
+# replace identical consecutive lines by "last message repeated N times"
+export LOG_COLLAPSE_REPEATED=false
@@ -0,0 +1,235 @@
+package log
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levels are shared by GlobalLogger and loggers created by Named,
+// they can be changed at runtime by SetLevel, SetNamedLevel or
+// ReloadLevelsOnSIGHUP
+var levels = newLevelRegistry()
+
+// levelRegistry holds the root level and levels of named loggers,
+// named levels are a copy-on-write map so reading them does not lock
+type levelRegistry struct {
+	root  zap.AtomicLevel
+	named atomic.Value // map[string]zap.AtomicLevel
+	mutex sync.Mutex   // serializes writers of named
+}
+
+func newLevelRegistry() *levelRegistry {
+	r := &levelRegistry{root: zap.NewAtomicLevel()}
+	r.named.Store(make(map[string]zap.AtomicLevel))
+	return r
+}
+
+// get returns the level of a logger name, a name "a.b" uses the level
+// of "a.b", then "a", then the root level
+func (r *levelRegistry) get(name string) zap.AtomicLevel {
+	named := r.named.Load().(map[string]zap.AtomicLevel)
+	for name != "" {
+		if level, found := named[name]; found {
+			return level
+		}
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return r.root
+}
+
+func (r *levelRegistry) setNamed(name string, level *zapcore.Level) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	old := r.named.Load().(map[string]zap.AtomicLevel)
+	named := make(map[string]zap.AtomicLevel, len(old)+1)
+	for k, v := range old {
+		named[k] = v
+	}
+	if level == nil {
+		delete(named, name)
+	} else {
+		named[name] = zap.NewAtomicLevelAt(*level)
+	}
+	r.named.Store(named)
+}
+
+// nameLevel is a zapcore_LevelEnabler that reads the level of a logger name
+// on every check, so the logger follows SetLevel and SetNamedLevel
+type nameLevel string
+
+func (n nameLevel) Enabled(l zapcore.Level) bool {
+	return levels.get(string(n)).Enabled(l)
+}
+
+// levelCore filters entries by a level that can be changed at runtime,
+// the wrapped core should enable all levels
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
+// ParseLevel parses "debug", "info", "warn", "error", "dpanic", "panic"
+// or "fatal" (case insensitive)
+func ParseLevel(text string) (zapcore.Level, error) {
+	var level zapcore.Level
+	err := level.UnmarshalText([]byte(strings.ToLower(strings.TrimSpace(text))))
+	return level, err
+}
+
+// GetLevel returns the root level
+func GetLevel() zapcore.Level {
+	return levels.root.Level()
+}
+
+// SetLevel changes the root level, it is the level of GlobalLogger (inited
+// from env vars or by InitGlobalLogger) and named loggers that do not have
+// their own level
+func SetLevel(level zapcore.Level) {
+	levels.root.SetLevel(level)
+}
+
+// SetNamedLevel changes the level of loggers returned by Named(name) and
+// their children, ex: SetNamedLevel("kafka", zap.WarnLevel)
+func SetNamedLevel(name string, level zapcore.Level) {
+	levels.setNamed(name, &level)
+}
+
+// UnsetNamedLevel makes the named loggers use the root level again
+func UnsetNamedLevel(name string) {
+	levels.setNamed(name, nil)
+}
+
+// NamedLevels returns the levels were set by SetNamedLevel
+func NamedLevels() map[string]zapcore.Level {
+	named := levels.named.Load().(map[string]zap.AtomicLevel)
+	ret := make(map[string]zapcore.Level, len(named))
+	for name, level := range named {
+		ret[name] = level.Level()
+	}
+	return ret
+}
+
+// Named returns a child logger of GlobalLogger that has a name (logged in
+// field "logger") and its own level (see SetNamedLevel), ex:
+//
+//	var logger = log.Named("kafka")
+//	logger.Debugf("consumed message %v", offset)
+func Named(name string) *zap.SugaredLogger {
+	return directLogger().Desugar().WithOptions(zap.WrapCore(
+		func(c zapcore.Core) zapcore.Core {
+			if lc, ok := c.(*levelCore); ok {
+				c = lc.Core
+			}
+			return &levelCore{Core: c, level: nameLevel(name)}
+		})).Named(name).Sugar()
+}
+
+// LoadLevelsFile sets levels from a file, each line is a level for the root
+// ("info") or a name ("kafka=warn"), lines begin with "#" are ignored.
+// Named levels that are not in the file are unset.
+func LoadLevelsFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var root *zapcore.Level
+	named := make(map[string]zapcore.Level)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, levelText := "", line
+		if i := strings.Index(line, "="); i >= 0 {
+			name, levelText = strings.TrimSpace(line[:i]), line[i+1:]
+		}
+		level, err := ParseLevel(levelText)
+		if err != nil {
+			return fmt.Errorf("line %v: %v", lineNo, err)
+		}
+		if name == "" {
+			root = &level
+		} else {
+			named[name] = level
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if root != nil {
+		SetLevel(*root)
+	}
+	for name := range NamedLevels() {
+		if _, found := named[name]; !found {
+			UnsetNamedLevel(name)
+		}
+	}
+	for name, level := range named {
+		SetNamedLevel(name, level)
+	}
+	return nil
+}
+
+// ReloadLevelsOnSIGHUP calls LoadLevelsFile every time the process receives
+// SIGHUP (ex: `kill -HUP {pid}` after editing the file), call the returned
+// func to stop listening, it returns after an in-progress reload finished
+func ReloadLevelsOnSIGHUP(filePath string) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		for {
+			select {
+			case <-sigChan:
+				if err := LoadLevelsFile(filePath); err != nil {
+					Errorf("error reload log levels from %v: %v", filePath, err)
+					continue
+				}
+				Infof("reloaded log levels from %v: root %v, named %v",
+					filePath, GetLevel(), NamedLevels())
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigChan)
+			close(stopChan)
+		})
+		<-doneChan
+	}
+}
@@ -0,0 +1,158 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// resetLevels restores the root level and removes all named levels
+func resetLevels(root zapcore.Level) {
+	SetLevel(root)
+	for name := range NamedLevels() {
+		UnsetNamedLevel(name)
+	}
+}
+
+func writeLevelsFile(t *testing.T, filePath string, content string) {
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamedLevelFallback(t *testing.T) {
+	defer resetLevels(GetLevel())
+	obsCore, logs := observer.New(zap.DebugLevel)
+	originalLogger := GlobalLogger
+	GlobalLogger = zap.New(&levelCore{Core: obsCore, level: nameLevel("")}).Sugar()
+	defer func() { GlobalLogger = originalLogger }()
+
+	SetLevel(zap.InfoLevel)
+	SetNamedLevel("kafka", zap.ErrorLevel)
+	SetNamedLevel("kafka.producer", zap.DebugLevel)
+	for _, name := range []string{"kafka", "kafka.consumer", "kafka.producer",
+		"kafka.producer.sarama", "kafkaesque", "websocket"} {
+		logger := Named(name)
+		logger.Debug(name + " debug")
+		logger.Warn(name + " warn")
+	}
+	Debug("root debug")
+	Info("root info")
+	var messages []string
+	for _, entry := range logs.TakeAll() {
+		messages = append(messages, entry.Message)
+	}
+	expected := []string{
+		"kafka.producer debug", "kafka.producer warn",
+		"kafka.producer.sarama debug", "kafka.producer.sarama warn",
+		"kafkaesque warn",
+		"websocket warn",
+		"root info",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestNewLoggerDoesNotChangeRootLevel(t *testing.T) {
+	defer resetLevels(GetLevel())
+	SetLevel(zap.WarnLevel)
+	logger := NewLogger(Config{Level: "debug", LogFilePath: filepath.Join(
+		os.TempDir(), "log_test_new_logger.log"), IsNotLogBoth: true, IsNotLogRotate: true})
+	defer os.Remove(filepath.Join(os.TempDir(), "log_test_new_logger.log"))
+	if GetLevel() != zap.WarnLevel {
+		t.Errorf("NewLogger changed the root level to %v", GetLevel())
+	}
+	if !logger.Desugar().Core().Enabled(zap.DebugLevel) {
+		t.Error("the new logger must have its own level debug")
+	}
+}
+
+func TestLoadLevelsFile(t *testing.T) {
+	defer resetLevels(GetLevel())
+	dir, err := ioutil.TempDir("", "log_levels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "levels.conf")
+
+	SetLevel(zap.DebugLevel)
+	SetNamedLevel("old", zap.WarnLevel)
+	writeLevelsFile(t, filePath, strings.Join([]string{
+		"# root level",
+		"INFO",
+		"",
+		"kafka = warn",
+		"  websocket=error  ",
+		"# ignored=debug",
+	}, "\n"))
+	if err := LoadLevelsFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]zapcore.Level{
+		"kafka": zap.WarnLevel, "websocket": zap.ErrorLevel}
+	if GetLevel() != zap.InfoLevel || !reflect.DeepEqual(NamedLevels(), expected) {
+		t.Errorf("unexpected levels: root %v, named %v", GetLevel(), NamedLevels())
+	}
+
+	// the root level is kept if the file does not have a root line,
+	// named levels that are not in the file are unset
+	writeLevelsFile(t, filePath, "kafka=debug\n")
+	if err := LoadLevelsFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]zapcore.Level{"kafka": zap.DebugLevel}
+	if GetLevel() != zap.InfoLevel || !reflect.DeepEqual(NamedLevels(), expected) {
+		t.Errorf("unexpected levels: root %v, named %v", GetLevel(), NamedLevels())
+	}
+
+	// an invalid file does not change any level
+	writeLevelsFile(t, filePath, "warn\n# comment\nwebsocket=verbose\n")
+	err = LoadLevelsFile(filePath)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected error at line 3, got %v", err)
+	}
+	if GetLevel() != zap.InfoLevel || !reflect.DeepEqual(NamedLevels(), expected) {
+		t.Errorf("unexpected levels: root %v, named %v", GetLevel(), NamedLevels())
+	}
+	if err := LoadLevelsFile(filepath.Join(dir, "not_exist")); err == nil {
+		t.Error("expected error for a not existed file")
+	}
+}
+
+func TestReloadLevelsOnSIGHUP(t *testing.T) {
+	defer resetLevels(GetLevel())
+	dir, err := ioutil.TempDir("", "log_levels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "levels.conf")
+	writeLevelsFile(t, filePath, "error\nkafka=debug\n")
+
+	SetLevel(zap.DebugLevel)
+	stop := ReloadLevelsOnSIGHUP(filePath)
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && GetLevel() != zap.ErrorLevel; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if GetLevel() != zap.ErrorLevel || NamedLevels()["kafka"] != zap.DebugLevel {
+		t.Errorf("levels were not reloaded: root %v, named %v",
+			GetLevel(), NamedLevels())
+	}
+	stop()
+	stop() // calling stop twice is safe
+}
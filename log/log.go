@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,13 +16,20 @@ import (
 )
 
 // GlobalLogger will be inited with config from env vars.
-// All funcs in this package use GlobalLogger
-var GlobalLogger *zap.SugaredLogger = NewLogger(NewConfigFromEnv())
+// All funcs in this package use GlobalLogger.
+// To use another config, call InitGlobalLogger instead of assigning
+// NewLogger to GlobalLogger: a logger returned by NewLogger has its own level,
+// so SetLevel (and the /__log/level endpoint of httpsvr) does not affect it.
+var GlobalLogger *zap.SugaredLogger = newLogger(NewConfigFromEnv(), true)
 
 // Config _
 type Config struct {
 	// default log level is debug
 	IsLogLevelInfo bool
+	// Level is "debug", "info", "warn" or "error", it overrides
+	// IsLogLevelInfo if not empty. The level of GlobalLogger can be changed
+	// at runtime by SetLevel.
+	Level string
 	// default log to stdout
 	LogFilePath string
 	// whether to log simultaneously to both stdout and file
@@ -30,39 +38,110 @@ type Config struct {
 	IsNotLogRotate bool
 	// default 24 hours (rotate at midnight)
 	RotateInterval time.Duration
+	// RotateLocation is the timezone that rotation times are aligned to,
+	// ex: gofast_VietnamTimeLoc() rotates at midnight +07:00, default UTC
+	RotateLocation *time.Location
+	// MaxSize is the max size in megabytes of the log file before it gets
+	// rotated (even if IsNotLogRotate), default 0 means 100 megabytes if the
+	// file is rotated by time, no limit otherwise
+	MaxSize int
+	// MaxBackups is the max number of rotated files to keep,
+	// default 0 means keep all
+	MaxBackups int
+	// MaxAge is the max number of days to keep rotated files,
+	// default 0 means keep all
+	MaxAge int
+	// IsCompress gzips the rotated files
+	IsCompress bool
+	// Format is FormatConsole (default) or FormatJSON (case insensitive)
+	Format string
+	// SampleFirst limits log lines of a message template (lines are written
+	// by a same caller): the first SampleFirst lines per second are written,
+	// then every SampleThereafter-th line. Lines that have level error or
+	// higher are not sampled. Default 0 means no sampling.
+	SampleFirst int
+	// default DefaultSampleThereafter
+	SampleThereafter int
+	// IsCollapseRepeated replaces identical consecutive lines by a line
+	// "last message repeated N times", the line is only written before the
+	// next different line or on Sync (not after a period of time)
+	IsCollapseRepeated bool
 }
 
+// DefaultSampleThereafter is used if Config_SampleThereafter is not set
+const DefaultSampleThereafter = 100
+
+// Log formats
+const (
+	// FormatConsole is human readable: time, level, caller, message then
+	// fields as a JSON object, separated by tabs
+	FormatConsole = "console"
+	// FormatJSON writes each log line as a JSON object, fields are keys
+	// of the object, for log shippers
+	FormatJSON = "json"
+)
+
 // NewConfigFromEnv reads env vars to return a Config
 func NewConfigFromEnv() Config {
 	var c Config
 	c.IsLogLevelInfo, _ = strconv.ParseBool(os.Getenv("LOG_LEVEL_INFO"))
+	c.Level = os.Getenv("LOG_LEVEL")
 	c.LogFilePath = os.Getenv("LOG_FILE_PATH")
 	c.IsNotLogBoth, _ = strconv.ParseBool(os.Getenv("LOG_NOT_STDOUT"))
 	c.IsNotLogRotate, _ = strconv.ParseBool(os.Getenv("LOG_NOT_ROTATE"))
 	c.RotateInterval = 24 * time.Hour
+	if tz := os.Getenv("LOG_ROTATE_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			fmt.Printf("invalid LOG_ROTATE_TIMEZONE %q: %v\n", tz, err)
+		}
+		c.RotateLocation = loc
+	}
+	c.MaxSize, _ = strconv.Atoi(os.Getenv("LOG_MAX_SIZE"))
+	c.MaxBackups, _ = strconv.Atoi(os.Getenv("LOG_MAX_BACKUPS"))
+	c.MaxAge, _ = strconv.Atoi(os.Getenv("LOG_MAX_AGE"))
+	c.IsCompress, _ = strconv.ParseBool(os.Getenv("LOG_COMPRESS"))
+	c.Format = os.Getenv("LOG_FORMAT")
+	c.SampleFirst, _ = strconv.Atoi(os.Getenv("LOG_SAMPLE_FIRST"))
+	c.SampleThereafter, _ = strconv.Atoi(os.Getenv("LOG_SAMPLE_THEREAFTER"))
+	c.IsCollapseRepeated, _ = strconv.ParseBool(os.Getenv("LOG_COLLAPSE_REPEATED"))
 	return c
 }
 
-// NewLogger returns a inited Logger
+// NewLogger returns a inited Logger, its level is conf_Level and is not
+// changed by SetLevel. Do not assign it to GlobalLogger, use InitGlobalLogger
+// to replace GlobalLogger so SetLevel keeps working.
 func NewLogger(conf Config) *zap.SugaredLogger {
+	return newLogger(conf, false)
+}
+
+// InitGlobalLogger replaces GlobalLogger by a logger of conf, it also sets
+// the root level (see SetLevel) to conf_Level
+func InitGlobalLogger(conf Config) {
+	GlobalLogger = newLogger(conf, true)
+}
+
+// newLogger returns a logger that follows the root level if isGlobal
+func newLogger(conf Config, isGlobal bool) *zap.SugaredLogger {
 	encoderConf := zap.NewProductionEncoderConfig()
 	encoderConf.EncodeTime = zapcore.ISO8601TimeEncoder
-	encoder := zapcore.NewConsoleEncoder(encoderConf)
+	var encoder zapcore.Encoder
+	switch format := strings.ToLower(strings.TrimSpace(conf.Format)); format {
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(encoderConf)
+	case FormatConsole, "":
+		encoder = zapcore.NewConsoleEncoder(encoderConf)
+	default:
+		fmt.Printf("invalid log format %q, use %v\n", conf.Format, FormatConsole)
+		encoder = zapcore.NewConsoleEncoder(encoderConf)
+	}
 
 	var writers []zapcore.WriteSyncer
 	stdWriter, _, _ := zap.Open("stdout")
 	if conf.LogFilePath == "" {
 		writers = []zapcore.WriteSyncer{stdWriter}
 	} else {
-		var fileWriter zapcore.WriteSyncer
-		if conf.IsNotLogRotate {
-			fileWriter, _, _ = zap.Open(conf.LogFilePath)
-		} else {
-			fileWriter = zapcore.AddSync(newTimedRotatingWriter(
-				&lumberjack.Logger{Filename: conf.LogFilePath},
-				conf.RotateInterval,
-			))
-		}
+		fileWriter := NewFileWriter(conf)
 		if conf.IsNotLogBoth {
 			writers = []zapcore.WriteSyncer{fileWriter}
 		} else {
@@ -75,36 +154,100 @@ func NewLogger(conf Config) *zap.SugaredLogger {
 	if conf.IsLogLevelInfo {
 		logLevel = zap.InfoLevel
 	}
-	core := zapcore.NewCore(encoder, combinedWriter, logLevel)
+	if conf.Level != "" {
+		if parsed, err := ParseLevel(conf.Level); err == nil {
+			logLevel = parsed
+		} else {
+			fmt.Printf("invalid log level %q, use %v\n", conf.Level, logLevel)
+		}
+	}
+	var level zapcore.LevelEnabler = zap.NewAtomicLevelAt(logLevel)
+	if isGlobal {
+		SetLevel(logLevel)
+		level = nameLevel("")
+	}
+	var core zapcore.Core = &levelCore{
+		Core: newSamplingCore(
+			zapcore.NewCore(encoder, combinedWriter, zap.DebugLevel), conf),
+		level: level,
+	}
 	zl := zap.New(core, zap.AddCaller())
 	zl = zl.WithOptions(zap.AddCallerSkip(1))
 	logger := zl.Sugar()
 	return logger
 }
 
+// NewFileWriter returns a writer to conf_LogFilePath, the file is rotated
+// base on conf (same as the file writer of NewLogger), fields about log level
+// and stdout are ignored. It can be used to write other logs, ex: access log
+func NewFileWriter(conf Config) zapcore.WriteSyncer {
+	base := newLumberjack(conf)
+	if conf.IsNotLogRotate {
+		if conf.MaxSize <= 0 {
+			fileWriter, _, _ := zap.Open(conf.LogFilePath)
+			return fileWriter
+		}
+		return zapcore.AddSync(base)
+	}
+	interval := conf.RotateInterval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	return zapcore.AddSync(newTimedRotatingWriter(base, interval, conf.RotateLocation))
+}
+
+// newLumberjack returns a size-based rotating writer of conf_LogFilePath
+func newLumberjack(conf Config) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   conf.LogFilePath,
+		MaxSize:    conf.MaxSize,
+		MaxBackups: conf.MaxBackups,
+		MaxAge:     conf.MaxAge,
+		Compress:   conf.IsCompress,
+	}
+}
+
 type timedRotatingWriter struct {
 	*lumberjack.Logger
-	interval    time.Duration
+	interval time.Duration
+	// loc is the timezone that rotation times are aligned to
+	loc         *time.Location
 	mutex       sync.RWMutex
 	lastRotated time.Time
+	// now exists so it can be mocked out by tests
+	now func() time.Time
 }
 
-func newTimedRotatingWriter(base *lumberjack.Logger, interval time.Duration) *timedRotatingWriter {
-	w := &timedRotatingWriter{Logger: base, interval: interval}
+func newTimedRotatingWriter(base *lumberjack.Logger, interval time.Duration,
+	loc *time.Location) *timedRotatingWriter {
+	if loc == nil {
+		loc = time.UTC
+	}
+	w := &timedRotatingWriter{Logger: base, interval: interval, loc: loc,
+		now: time.Now}
 	w.mutex.Lock()
 	w.Logger.Rotate()
-	w.lastRotated = time.Now().Truncate(interval)
+	w.lastRotated = truncateInLocation(w.now(), interval, loc)
 	w.mutex.Unlock()
 	return w
 }
 
+// truncateInLocation rounds t down to a multiple of d since the zero time in
+// loc, ex: d = 24h, loc = +07:00 returns the last midnight in Vietnam
+func truncateInLocation(t time.Time, d time.Duration, loc *time.Location) time.Time {
+	_, offset := t.In(loc).Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(d).Add(-shift).In(t.Location())
+}
+
 func (w *timedRotatingWriter) rotateIfNeeded() error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	if time.Now().Sub(w.lastRotated) < w.interval {
+	now := w.now()
+	if now.Sub(w.lastRotated) < w.interval {
 		return nil
 	}
-	w.lastRotated = time.Now().Truncate(w.interval)
+	w.lastRotated = truncateInLocation(now, w.interval, w.loc)
 	fmt.Printf("%v about to rotate log file\n", w.lastRotated)
 	err := w.Logger.Rotate()
 	return err
@@ -138,6 +281,22 @@ func Infof(format string, args ...interface{}) {
 	GlobalLogger.Infof(format, args...)
 }
 
+func Warn(args ...interface{}) {
+	GlobalLogger.Warn(args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	GlobalLogger.Warnf(format, args...)
+}
+
+func Error(args ...interface{}) {
+	GlobalLogger.Error(args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	GlobalLogger.Errorf(format, args...)
+}
+
 func Debug(args ...interface{}) {
 	GlobalLogger.Debug(args...)
 }
@@ -146,6 +305,41 @@ func Debugf(format string, args ...interface{}) {
 	GlobalLogger.Debugf(format, args...)
 }
 
+// Infow logs a message with key-value pairs,
+// ex: log.Infow("user logged in", "userId", 1, "ip", "10.0.0.1")
+func Infow(msg string, keysAndValues ...interface{}) {
+	GlobalLogger.Infow(msg, keysAndValues...)
+}
+
+// Debugw logs a message with key-value pairs, see Infow
+func Debugw(msg string, keysAndValues ...interface{}) {
+	GlobalLogger.Debugw(msg, keysAndValues...)
+}
+
+// Warnw logs a message with key-value pairs, see Infow
+func Warnw(msg string, keysAndValues ...interface{}) {
+	GlobalLogger.Warnw(msg, keysAndValues...)
+}
+
+// Errorw logs a message with key-value pairs, see Infow
+func Errorw(msg string, keysAndValues ...interface{}) {
+	GlobalLogger.Errorw(msg, keysAndValues...)
+}
+
+// Fatalw logs a message with key-value pairs then calls os_Exit(1)
+func Fatalw(msg string, keysAndValues ...interface{}) {
+	GlobalLogger.Fatalw(msg, keysAndValues...)
+}
+
+// With returns a child logger of GlobalLogger that adds key-value pairs
+// to all its log lines, ex:
+//
+//	logger := log.With("topic", topic, "partition", partition)
+//	logger.Infof("consumed offset %v", offset)
+func With(keysAndValues ...interface{}) *zap.SugaredLogger {
+	return directLogger().With(keysAndValues...)
+}
+
 func Print(args ...interface{}) {
 	GlobalLogger.Info(args...)
 }
@@ -194,6 +388,22 @@ func (l StdLogger) Printf(format string, args ...interface{}) {
 	GlobalLogger.Infof(format, args...)
 }
 
+func (l StdLogger) Warn(args ...interface{}) {
+	GlobalLogger.Warn(padArgs(args)...)
+}
+
+func (l StdLogger) Warnf(format string, args ...interface{}) {
+	GlobalLogger.Warnf(format, args...)
+}
+
+func (l StdLogger) Error(args ...interface{}) {
+	GlobalLogger.Error(padArgs(args)...)
+}
+
+func (l StdLogger) Errorf(format string, args ...interface{}) {
+	GlobalLogger.Errorf(format, args...)
+}
+
 func (l *StdLogger) Fatal(v ...interface{}) {
 	GlobalLogger.Fatal(padArgs(v)...)
 }
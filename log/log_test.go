@@ -0,0 +1,216 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daominah/gomicrokit/gofast"
+	"github.com/natefinch/lumberjack"
+)
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	ret, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestTruncateInLocation(t *testing.T) {
+	vn := gofast.VietnamTimeLoc()
+	for i, c := range []struct {
+		t        string
+		interval time.Duration
+		loc      *time.Location
+		expected string
+	}{
+		{"2020-03-27T11:00:31Z", 24 * time.Hour, time.UTC, "2020-03-27T00:00:00Z"},
+		{"2020-03-27T11:00:31Z", 24 * time.Hour, vn, "2020-03-26T17:00:00Z"},
+		{"2020-03-27T16:59:59Z", 24 * time.Hour, vn, "2020-03-26T17:00:00Z"},
+		{"2020-03-27T17:00:00Z", 24 * time.Hour, vn, "2020-03-27T17:00:00Z"},
+		{"2020-03-27T00:30:00+07:00", 24 * time.Hour, vn, "2020-03-27T00:00:00+07:00"},
+		{"2020-03-27T23:59:59+07:00", 24 * time.Hour, vn, "2020-03-27T00:00:00+07:00"},
+		{"2020-03-27T11:00:31Z", time.Hour, vn, "2020-03-27T11:00:00Z"},
+		{"2020-03-27T11:00:31Z", 6 * time.Hour, vn, "2020-03-27T11:00:00Z"},
+		{"2020-03-27T10:59:59Z", 6 * time.Hour, vn, "2020-03-27T05:00:00Z"},
+	} {
+		got := truncateInLocation(mustParseTime(t, c.t), c.interval, c.loc)
+		if expected := mustParseTime(t, c.expected); !got.Equal(expected) {
+			t.Errorf("case %v: expected %v, got %v", i, expected, got)
+		}
+	}
+}
+
+func TestTimedRotatingWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "app.log")
+
+	vn := gofast.VietnamTimeLoc()
+	now := mustParseTime(t, "2020-03-27T23:00:00+07:00")
+	base := &lumberjack.Logger{Filename: filePath}
+	defer base.Close()
+	w := newTimedRotatingWriter(base, 24*time.Hour, vn)
+	w.now = func() time.Time { return now }
+	w.lastRotated = truncateInLocation(now, w.interval, vn)
+
+	for i, c := range []struct {
+		now      string
+		line     string
+		expected string // content of the current file after writing
+	}{
+		{"2020-03-27T23:00:00+07:00", "a\n", "a\n"},
+		// midnight in UTC is not a boundary
+		{"2020-03-28T06:59:59+07:00", "b\n", "b\n"},
+		{"2020-03-28T07:00:00+07:00", "c\n", "b\nc\n"},
+		{"2020-03-28T23:59:59+07:00", "d\n", "b\nc\nd\n"},
+		{"2020-03-29T00:00:00+07:00", "e\n", "e\n"},
+		// skipped days rotate once
+		{"2020-04-02T12:00:00+07:00", "f\n", "f\n"},
+		{"2020-04-02T13:00:00+07:00", "g\n", "f\ng\n"},
+	} {
+		now = mustParseTime(t, c.now)
+		if _, err := w.Write([]byte(c.line)); err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if string(content) != c.expected {
+			t.Errorf("case %v: expected file content %q, got %q", i, c.expected, content)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	for key, value := range map[string]string{
+		"LOG_FILE_PATH":       "/var/log/app.log",
+		"LOG_ROTATE_TIMEZONE": "Asia/Ho_Chi_Minh",
+		"LOG_MAX_SIZE":        "50",
+		"LOG_MAX_BACKUPS":     "7",
+		"LOG_MAX_AGE":         "30",
+		"LOG_COMPRESS":        "true",
+	} {
+		if original, found := os.LookupEnv(key); found {
+			defer os.Setenv(key, original)
+		} else {
+			defer os.Unsetenv(key)
+		}
+		os.Setenv(key, value)
+	}
+	conf := NewConfigFromEnv()
+	if conf.RotateLocation == nil || conf.RotateLocation.String() != "Asia/Ho_Chi_Minh" {
+		t.Errorf("unexpected RotateLocation %v", conf.RotateLocation)
+	}
+	got := newLumberjack(conf)
+	if got.Filename != "/var/log/app.log" || got.MaxSize != 50 ||
+		got.MaxBackups != 7 || got.MaxAge != 30 || !got.Compress {
+		t.Errorf("unexpected lumberjack config %v, %v, %v, %v, %v", got.Filename,
+			got.MaxSize, got.MaxBackups, got.MaxAge, got.Compress)
+	}
+}
+
+// callerLine returns "file:line" of the caller, only the file base name
+func callerLine() string {
+	_, file, line, _ := runtime.Caller(1)
+	return filepath.Base(file) + ":" + strconv.Itoa(line)
+}
+
+func TestJSONFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "app.log")
+	originalLogger := GlobalLogger
+	defer func() { GlobalLogger = originalLogger }()
+	GlobalLogger = NewLogger(Config{Format: FormatJSON, LogFilePath: filePath,
+		IsNotLogBoth: true, IsNotLogRotate: true})
+
+	var callers []string
+	Infow("user logged in", "userId", 7, "ip", "10.0.0.1")
+	callers = append(callers, callerLine())
+	With("topic", "orders").Infof("consumed offset %v", 100)
+	callers = append(callers, callerLine())
+	Infof("plain %v", "message")
+	callers = append(callers, callerLine())
+	GlobalLogger.Sync()
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line is not JSON: %v: %s", err, scanner.Bytes())
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %s", content)
+	}
+	for i, c := range []struct {
+		msg    string
+		fields map[string]interface{}
+	}{
+		{"user logged in", map[string]interface{}{"userId": 7.0, "ip": "10.0.0.1"}},
+		{"consumed offset 100", map[string]interface{}{"topic": "orders"}},
+		{"plain message", nil},
+	} {
+		line := lines[i]
+		if line["msg"] != c.msg || line["level"] != "info" || line["ts"] == nil {
+			t.Errorf("case %v: unexpected line %v", i, line)
+		}
+		for k, v := range c.fields {
+			if line[k] != v {
+				t.Errorf("case %v: field %v: expected %v, got %v", i, k, v, line[k])
+			}
+		}
+		// the caller is the call site, the line before callerLine()
+		expectedFile := strings.Split(callers[i], ":")[0]
+		expectedLine, _ := strconv.Atoi(strings.Split(callers[i], ":")[1])
+		expectedCaller := expectedFile + ":" + strconv.Itoa(expectedLine-1)
+		if caller, _ := line["caller"].(string); filepath.Base(caller) != expectedCaller {
+			t.Errorf("case %v: expected caller %v, got %v", i, expectedCaller, caller)
+		}
+	}
+}
+
+func TestFormatCaseInsensitive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "app.log")
+	logger := NewLogger(Config{Format: " JSON", LogFilePath: filePath,
+		IsNotLogBoth: true, IsNotLogRotate: true})
+	logger.Info("hello")
+	logger.Sync()
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(content), &line); err != nil ||
+		line["msg"] != "hello" {
+		t.Errorf("expected a JSON line, got %s", content)
+	}
+}
@@ -0,0 +1,177 @@
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// sampleTick is the period of Config_SampleFirst
+const sampleTick = time.Second
+
+// samplingCore drops and collapses log lines before writing them to the
+// wrapped core, its state is shared by all cores derived by With
+type samplingCore struct {
+	zapcore.Core
+	state *samplingState
+}
+
+type samplingState struct {
+	// first lines per second per template are written, then every
+	// thereafter-th line, first <= 0 disables sampling
+	first      int
+	thereafter int
+	// isCollapse enables writing "repeated N times" instead of identical lines
+	isCollapse bool
+
+	mutex    sync.Mutex
+	counters map[sampleKey]*sampleCounter
+	last     *lastEntry
+}
+
+// sampleKey is the level and the caller of a log line, a caller writes a same
+// message template (ex: Infof("received message %v", msg)) so it is used
+// instead of the formatted message
+type sampleKey struct {
+	level  zapcore.Level
+	caller string
+}
+
+type sampleCounter struct {
+	resetAt time.Time
+	n       int
+}
+
+// lastEntry is the last written log line and how many identical lines
+// were dropped after it
+type lastEntry struct {
+	core     zapcore.Core
+	entry    zapcore.Entry
+	fields   []zapcore.Field
+	repeated int
+}
+
+// newSamplingCore returns core if sampling and collapsing are disabled
+func newSamplingCore(core zapcore.Core, conf Config) zapcore.Core {
+	if conf.SampleFirst <= 0 && !conf.IsCollapseRepeated {
+		return core
+	}
+	thereafter := conf.SampleThereafter
+	if thereafter <= 0 {
+		thereafter = DefaultSampleThereafter
+	}
+	return &samplingCore{Core: core, state: &samplingState{
+		first:      conf.SampleFirst,
+		thereafter: thereafter,
+		isCollapse: conf.IsCollapseRepeated,
+		counters:   make(map[sampleKey]*sampleCounter),
+	}}
+}
+
+func (c *samplingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &samplingCore{Core: c.Core.With(fields), state: c.state}
+}
+
+// Check adds this core instead of the wrapped core, because the caller of
+// the entry is only known at Write
+func (c *samplingCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+// Write only holds the mutex for counters and the last entry, the wrapped
+// core is written outside the lock
+func (c *samplingCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	s := c.state
+	s.mutex.Lock()
+	if !s.isSampled(e) {
+		s.mutex.Unlock()
+		return nil
+	}
+	var repeated *lastEntry
+	if s.isCollapse {
+		if s.last != nil && s.last.core == c.Core && isIdentical(s.last, e, fields) {
+			s.last.repeated++
+			s.mutex.Unlock()
+			return nil
+		}
+		repeated = s.takeRepeated()
+		s.last = &lastEntry{core: c.Core, entry: e, fields: fields}
+	}
+	s.mutex.Unlock()
+
+	err := writeRepeated(repeated)
+	if writeErr := c.Core.Write(e, fields); writeErr != nil {
+		err = writeErr
+	}
+	return err
+}
+
+func (c *samplingCore) Sync() error {
+	c.state.mutex.Lock()
+	repeated := c.state.takeRepeated()
+	c.state.mutex.Unlock()
+	err := writeRepeated(repeated)
+	if syncErr := c.Core.Sync(); syncErr != nil {
+		err = syncErr
+	}
+	return err
+}
+
+// isSampled returns whether the entry should be written, entries that have
+// level error or higher are always written
+func (s *samplingState) isSampled(e zapcore.Entry) bool {
+	if s.first <= 0 || e.Level >= zapcore.ErrorLevel {
+		return true
+	}
+	key := sampleKey{level: e.Level, caller: e.Caller.String()}
+	if !e.Caller.Defined {
+		key.caller = e.Message
+	}
+	counter, found := s.counters[key]
+	if !found {
+		counter = &sampleCounter{}
+		s.counters[key] = counter
+	}
+	if !e.Time.Before(counter.resetAt) {
+		counter.resetAt = e.Time.Add(sampleTick)
+		counter.n = 0
+	}
+	counter.n++
+	return counter.n <= s.first || (counter.n-s.first)%s.thereafter == 0
+}
+
+// takeRepeated returns a copy of the last entry if identical lines were
+// dropped after it and resets the count, it must be called with the mutex
+func (s *samplingState) takeRepeated() *lastEntry {
+	if s.last == nil || s.last.repeated == 0 {
+		return nil
+	}
+	ret := *s.last
+	s.last.repeated = 0
+	return &ret
+}
+
+// writeRepeated writes "last message repeated N times" for the entry
+// returned by takeRepeated, does nothing if it is nil
+func writeRepeated(last *lastEntry) error {
+	if last == nil {
+		return nil
+	}
+	e := last.entry
+	e.Time = time.Now()
+	e.Message = fmt.Sprintf("last message repeated %v times", last.repeated)
+	return last.core.Write(e, nil)
+}
+
+func isIdentical(last *lastEntry, e zapcore.Entry, fields []zapcore.Field) bool {
+	return last.entry.Level == e.Level && last.entry.Message == e.Message &&
+		last.entry.LoggerName == e.LoggerName &&
+		last.entry.Caller == e.Caller &&
+		reflect.DeepEqual(last.fields, fields)
+}
@@ -0,0 +1,100 @@
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSampling(t *testing.T) {
+	obsCore, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(newSamplingCore(obsCore,
+		Config{SampleFirst: 3, SampleThereafter: 5}), zap.AddCaller()).Sugar()
+	for i := 0; i < 20; i++ {
+		logger.Infof("hot message %v", i)
+		if i%10 == 0 {
+			logger.Infof("other message %v", i)
+		}
+		logger.Errorf("error %v", i)
+	}
+	var hot, other, errs int
+	for _, entry := range logs.TakeAll() {
+		switch entry.Level {
+		case zap.ErrorLevel:
+			errs++
+		default:
+			if entry.Message[:3] == "hot" {
+				hot++
+			} else {
+				other++
+			}
+		}
+	}
+	// first 3 then the 8th, 13th and 18th
+	if hot != 6 || other != 2 || errs != 20 {
+		t.Errorf("unexpected number of lines: hot %v, other %v, errors %v",
+			hot, other, errs)
+	}
+}
+
+func TestCollapseRepeated(t *testing.T) {
+	obsCore, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(newSamplingCore(obsCore,
+		Config{IsCollapseRepeated: true}), zap.AddCaller()).Sugar()
+	for i := 0; i < 4; i++ {
+		logger.Infow("connection refused", "host", "kafka0")
+	}
+	logger.Infow("connection refused", "host", "kafka1")
+	for i := 0; i < 2; i++ {
+		logger.Info("connected")
+	}
+	logger.Sync()
+	var messages []string
+	for _, entry := range logs.TakeAll() {
+		messages = append(messages, entry.Message)
+	}
+	expected := []string{
+		"connection refused",
+		"last message repeated 3 times",
+		"connection refused",
+		"connected",
+		"last message repeated 1 times",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestCollapseRepeatedConcurrent(t *testing.T) {
+	obsCore, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(newSamplingCore(obsCore,
+		Config{IsCollapseRepeated: true}), zap.AddCaller()).Sugar()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.Info("connection refused")
+			}
+		}()
+	}
+	wg.Wait()
+	logger.Sync()
+	total := 0
+	for _, entry := range logs.TakeAll() {
+		var n int
+		if _, err := fmt.Sscanf(entry.Message, "last message repeated %d times", &n); err == nil {
+			total += n
+		} else {
+			total++
+		}
+	}
+	if total != 800 {
+		t.Errorf("expected 800 lines in total, got %v", total)
+	}
+}
@@ -0,0 +1,126 @@
+package metric
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrometheusConfig configs WritePrometheusWithConf
+type PrometheusConfig struct {
+	// Name is the metric family name, default "request_duration_seconds"
+	Name string
+	// Help is the description of the metric family
+	Help string
+	// KeyToLabels converts a metric key to labels,
+	// default converts key to a label {key="$key"}
+	KeyToLabels func(key string) map[string]string
+}
+
+// WritePrometheus writes current metric of m in Prometheus text exposition
+// format, each key is a summary (count, total seconds and percentiles 68,
+// 95, 99.7) with a label "key"
+func WritePrometheus(w io.Writer, m Metric) error {
+	return WritePrometheusWithConf(w, m, PrometheusConfig{})
+}
+
+// WritePrometheusWithConf is WritePrometheus with custom name and labels
+func WritePrometheusWithConf(w io.Writer, m Metric, conf PrometheusConfig) error {
+	name := conf.Name
+	if name == "" {
+		name = "request_duration_seconds"
+	}
+	name = sanitizePrometheusName(name, true)
+	help := conf.Help
+	if help == "" {
+		help = "Count and duration of requests"
+	}
+	keyToLabels := conf.KeyToLabels
+	if keyToLabels == nil {
+		keyToLabels = func(key string) map[string]string {
+			return map[string]string{"key": key}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HELP %v %v\n", name, escapePrometheusHelp(help))
+	fmt.Fprintf(bw, "# TYPE %v summary\n", name)
+	for _, row := range m.GetCurrentMetric() {
+		labels := keyToLabels(row.Key)
+		for _, q := range []struct {
+			quantile string
+			value    float64
+		}{
+			{"0.6827", row.Percentile68.Seconds()},
+			{"0.9545", row.Percentile95.Seconds()},
+			{"0.9973", row.Percentile997.Seconds()},
+		} {
+			fmt.Fprintf(bw, "%v%v %v\n", name,
+				formatPrometheusLabels(labels, "quantile", q.quantile),
+				formatPrometheusValue(q.value))
+		}
+		fmt.Fprintf(bw, "%v_sum%v %v\n", name, formatPrometheusLabels(labels),
+			formatPrometheusValue(row.TotalDuration.Seconds()))
+		fmt.Fprintf(bw, "%v_count%v %v\n", name, formatPrometheusLabels(labels),
+			row.Count)
+	}
+	return bw.Flush()
+}
+
+// formatPrometheusLabels returns labels sorted by name, ex: {a="1",b="2"},
+// extra is pairs of label name and value that will be appended
+func formatPrometheusLabels(labels map[string]string, extra ...string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`,
+			sanitizePrometheusName(name, false), escapePrometheusLabel(labels[name])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`,
+			extra[i], escapePrometheusLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var prometheusHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapePrometheusLabel(value string) string {
+	return prometheusLabelEscaper.Replace(value)
+}
+
+func escapePrometheusHelp(help string) string {
+	return prometheusHelpEscaper.Replace(help)
+}
+
+// sanitizePrometheusName replaces invalid chars by "_",
+// metric name can contain colons, label name cannot
+func sanitizePrometheusName(name string, isMetricName bool) string {
+	ret := []byte(name)
+	for i, c := range ret {
+		isValid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9') || (isMetricName && c == ':')
+		if !isValid {
+			ret[i] = '_'
+		}
+	}
+	if len(ret) == 0 {
+		return "_"
+	}
+	return string(ret)
+}
+
+func formatPrometheusValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
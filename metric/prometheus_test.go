@@ -0,0 +1,57 @@
+package metric
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := NewMemoryMetric()
+	for i := 1; i <= 4; i++ {
+		m.Count("path0")
+		m.Duration("path0", time.Duration(i)*time.Second)
+	}
+	m.Count("weird\"key\\\n")
+	buf := bytes.NewBuffer(nil)
+	err := WritePrometheus(buf, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `# HELP request_duration_seconds Count and duration of requests
+# TYPE request_duration_seconds summary
+request_duration_seconds{key="path0",quantile="0.6827"} 3
+request_duration_seconds{key="path0",quantile="0.9545"} 4
+request_duration_seconds{key="path0",quantile="0.9973"} 4
+request_duration_seconds_sum{key="path0"} 10
+request_duration_seconds_count{key="path0"} 4
+request_duration_seconds{key="weird\"key\\\n",quantile="0.6827"} 0
+request_duration_seconds{key="weird\"key\\\n",quantile="0.9545"} 0
+request_duration_seconds{key="weird\"key\\\n",quantile="0.9973"} 0
+request_duration_seconds_sum{key="weird\"key\\\n"} 0
+request_duration_seconds_count{key="weird\"key\\\n"} 1
+`
+	if buf.String() != expect {
+		t.Errorf("unexpected output:\n%v", buf.String())
+	}
+}
+
+func TestWritePrometheusWithConf(t *testing.T) {
+	m := NewMemoryMetric()
+	m.Count("/users_GET")
+	buf := bytes.NewBuffer(nil)
+	err := WritePrometheusWithConf(buf, m, PrometheusConfig{
+		Name: "http.server-duration",
+		KeyToLabels: func(key string) map[string]string {
+			return map[string]string{"path": "/users", "method": "GET", "0bad": "x"}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `http_server_duration_count{_bad="x",method="GET",path="/users"} 1`
+	if !strings.Contains(buf.String(), line) {
+		t.Errorf("expected line %v in output:\n%v", line, buf.String())
+	}
+}